@@ -0,0 +1,81 @@
+package redaction
+
+import (
+	"context"
+)
+
+// cancelCheckInterval is the number of values traversed between checks of the context for cancellation.
+const cancelCheckInterval = 256
+
+type groupsKey struct{}
+
+// WithGroups returns a copy of ctx that carries the groups of the viewer, they are read by RedactRecordCtx.
+func WithGroups(ctx context.Context, groups ...string) context.Context {
+	return context.WithValue(ctx, groupsKey{}, append([]string(nil), groups...))
+}
+
+// GroupsFromContext returns the groups stored in ctx by WithGroups.
+func GroupsFromContext(ctx context.Context) []string {
+	groups, _ := ctx.Value(groupsKey{}).([]string)
+	return groups
+}
+
+// GroupResolver derives the groups of the viewer from a context, e.g. from the auth claims of a request.
+type GroupResolver interface {
+	ResolveGroups(ctx context.Context) ([]string, error)
+}
+
+// GroupResolverFunc adapts a function to a GroupResolver.
+type GroupResolverFunc func(ctx context.Context) ([]string, error)
+
+func (f GroupResolverFunc) ResolveGroups(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// contextGroups is the GroupResolver used by redactors that do not set WithGroupResolver.
+var contextGroups = GroupResolverFunc(func(ctx context.Context) ([]string, error) {
+	return GroupsFromContext(ctx), nil
+})
+
+// resolveGroups returns the groups of the viewer of ctx, the default group is used by the caller if there are none.
+func (r *Redactor) resolveGroups(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.groupResolver.ResolveGroups(ctx)
+}
+
+// RedactRecordCtx redacts a record the same way RedactRecord does, the groups are resolved from ctx.
+// Redaction stops with the error of ctx when it is cancelled.
+func RedactRecordCtx[T any](ctx context.Context, record T) (T, error) {
+	return RedactWithCtx(ctx, defaultRedactor, record)
+}
+
+// RedactWithCtx redacts a record the same way RedactRecordCtx does, using the configuration of the given Redactor.
+func RedactWithCtx[T any](ctx context.Context, r *Redactor, record T) (T, error) {
+	groups, err := r.resolveGroups(ctx)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return redactWith(ctx, r, record, groups)
+}
+
+// RedactInPlaceCtx redacts a record the same way RedactInPlace does, the groups are resolved from ctx.
+// When ctx is cancelled the record is set to its zero value and the error of ctx is returned.
+func RedactInPlaceCtx[T any](ctx context.Context, record *T) error {
+	return RedactInPlaceWithCtx(ctx, defaultRedactor, record)
+}
+
+// RedactInPlaceWithCtx redacts a record the same way RedactInPlaceCtx does, using the configuration of the given Redactor.
+func RedactInPlaceWithCtx[T any](ctx context.Context, r *Redactor, record *T) error {
+	groups, err := r.resolveGroups(ctx)
+	if err != nil {
+		if record != nil {
+			var zero T
+			*record = zero
+		}
+		return err
+	}
+	return redactInPlaceWith(ctx, r, record, groups)
+}
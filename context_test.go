@@ -0,0 +1,73 @@
+package redaction
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type claimsKey struct{}
+
+type cancelRecord struct {
+	Name string `redact:"all=test_cancel"`
+}
+
+func TestRedactRecordCtx(t *testing.T) {
+	t.Run("groups", func(t *testing.T) {
+		clean, err := RedactRecordCtx(WithGroups(context.Background(), "admin"), newUserRecord())
+		require.NoError(t, err)
+		requireUserRedacted(t, true, clean)
+		clean, err = RedactRecordCtx(context.Background(), newUserRecord())
+		require.NoError(t, err)
+		requireUserRedacted(t, false, clean)
+		require.Equal(t, []string{"admin", "csr"}, GroupsFromContext(WithGroups(context.Background(), "admin", "csr")))
+	})
+	t.Run("resolver", func(t *testing.T) {
+		var errNoClaims = errors.New("no claims")
+		r := New(WithGroupResolver(GroupResolverFunc(func(ctx context.Context) ([]string, error) {
+			roles, ok := ctx.Value(claimsKey{}).([]string)
+			if !ok {
+				return nil, errNoClaims
+			}
+			return roles, nil
+		})))
+		clean, err := RedactWithCtx(context.WithValue(context.Background(), claimsKey{}, []string{"admin"}), r, newUserRecord())
+		require.NoError(t, err)
+		requireUserRedacted(t, true, clean)
+		clean, err = RedactWithCtx(context.Background(), r, newUserRecord())
+		require.True(t, errors.Is(err, errNoClaims))
+		require.Equal(t, userRecord{}, clean)
+	})
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		clean, err := RedactRecordCtx(ctx, newUserRecord())
+		require.True(t, errors.Is(err, context.Canceled))
+		require.Equal(t, userRecord{}, clean)
+		rec := newUserRecord()
+		require.True(t, errors.Is(RedactInPlaceCtx(ctx, &rec), context.Canceled))
+		require.Equal(t, userRecord{}, rec)
+	})
+	t.Run("cancelled while redacting", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var calls int
+		r := New()
+		require.NoError(t, r.RegisterMethod("test_cancel", func(arguments ...Arg) (Redaction, error) {
+			return func(value any) error {
+				calls++
+				cancel()
+				return nil
+			}, nil
+		}))
+		recs := make([]cancelRecord, 10000)
+		clean, err := RedactWithCtx(ctx, r, recs)
+		require.True(t, errors.Is(err, context.Canceled))
+		require.Nil(t, clean)
+		require.Less(t, calls, cancelCheckInterval)
+		calls = 0
+		require.True(t, errors.Is(RedactInPlaceWithCtx(ctx, r, &recs), context.Canceled))
+		require.Nil(t, recs)
+	})
+}
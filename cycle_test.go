@@ -0,0 +1,133 @@
+package redaction
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type listNode struct {
+	Value string `redact:"~admin=star(1)"`
+	Next  *listNode
+}
+
+type parentNode struct {
+	Name     string `redact:"all=remove(1)"`
+	Children []*childNode
+}
+
+type childNode struct {
+	Email  string `redact:"~admin=star(2)"`
+	Parent *parentNode
+}
+
+type sharedRecord struct {
+	A     *userRecord
+	B     *userRecord
+	C     []*userRecord
+	Name  *string  `redact:"~admin=star(1)"`
+	Alias *string  `redact:"~admin=star(1)"`
+	Other *string  `redact:"all=remove(2)"`
+	Names []string `redact:"~admin=star(1)"`
+	Again []string `redact:"~admin=star(1)"`
+}
+
+func TestRedactCycles(t *testing.T) {
+	t.Run("linked list cycle", func(t *testing.T) {
+		a := &listNode{Value: "first"}
+		b := &listNode{Value: "second", Next: a}
+		a.Next = b
+		clean, err := RedactRecord(a)
+		require.NoError(t, err)
+		require.Equal(t, "f****", clean.Value)
+		require.Equal(t, "s*****", clean.Next.Value)
+		require.Same(t, clean, clean.Next.Next)
+		require.NotSame(t, a, clean)
+		require.Equal(t, "first", a.Value)
+	})
+	t.Run("self reference", func(t *testing.T) {
+		a := &listNode{Value: "self"}
+		a.Next = a
+		clean, err := RedactRecord(a, "admin")
+		require.NoError(t, err)
+		require.Equal(t, "self", clean.Value)
+		require.Same(t, clean, clean.Next)
+	})
+	t.Run("parent pointers", func(t *testing.T) {
+		parent := &parentNode{Name: "parent"}
+		parent.Children = []*childNode{
+			{Email: "a@test.com", Parent: parent},
+			{Email: "b@test.com", Parent: parent},
+		}
+		clean, err := RedactRecord(parent)
+		require.NoError(t, err)
+		require.Equal(t, "p", clean.Name)
+		require.Len(t, clean.Children, 2)
+		for _, child := range clean.Children {
+			require.Equal(t, "********", child.Email[2:])
+			require.Same(t, clean, child.Parent)
+		}
+		require.Equal(t, "parent", parent.Name)
+	})
+	t.Run("shared references", func(t *testing.T) {
+		shared := &[]userRecord{newUserRecord()}[0]
+		clean, err := RedactRecord(sharedRecord{A: shared, B: shared, C: []*userRecord{shared}})
+		require.NoError(t, err)
+		require.Same(t, clean.A, clean.B)
+		require.Same(t, clean.A, clean.C[0])
+		require.NotSame(t, shared, clean.A)
+		requireUserRedacted(t, false, *clean.A)
+	})
+	t.Run("shared tagged references", func(t *testing.T) {
+		name := strPointer("name")
+		names := []string{"name"}
+		rec := sharedRecord{Name: name, Alias: name, Other: name, Names: names, Again: names}
+		clean, err := RedactRecord(rec)
+		require.NoError(t, err)
+		require.Same(t, clean.Name, clean.Alias)
+		require.Equal(t, "n***", *clean.Name)
+		// A reference redacted with a different tag gets its own copy.
+		require.Equal(t, "na", *clean.Other)
+		require.Equal(t, []string{"n***"}, clean.Names)
+		require.Same(t, &clean.Names[0], &clean.Again[0])
+		require.Equal(t, "name", *name)
+	})
+	t.Run("cyclic slice", func(t *testing.T) {
+		cyclic := []any{"value", nil}
+		cyclic[1] = cyclic
+		clean, err := RedactValue(cyclic, "all=star")
+		require.NoError(t, err)
+		require.Equal(t, "*****", clean[0])
+		require.Equal(t, "*****", clean[1].([]any)[0])
+	})
+	t.Run("cyclic map", func(t *testing.T) {
+		m := map[string]any{"user": newUserRecord()}
+		m["self"] = m
+		clean, err := RedactRecord(m)
+		require.NoError(t, err)
+		requireUserRedacted(t, false, clean["user"].(userRecord))
+		require.Equal(t, clean["self"].(map[string]any)["self"].(map[string]any)["user"], clean["user"])
+	})
+}
+
+func TestRedactMaxDepth(t *testing.T) {
+	var head *listNode
+	for i := 0; i < 100; i++ {
+		head = &listNode{Value: "node", Next: head}
+	}
+	clean, err := RedactWith(New(WithMaxDepth(50)), head)
+	require.Nil(t, clean)
+	require.True(t, errors.Is(err, ErrMaxDepth))
+	var depthErr *DepthError
+	require.True(t, errors.As(err, &depthErr))
+	require.Equal(t, 50, depthErr.Limit)
+
+	clean, err = RedactWith(New(WithMaxDepth(0)), head)
+	require.NoError(t, err)
+	require.Equal(t, "n***", clean.Next.Next.Value)
+
+	// Errors from exceeding the depth are never treated as field errors.
+	_, err = RedactWith(New(WithMaxDepth(50), WithErrorPolicy(FailOpen)), head)
+	require.True(t, errors.Is(err, ErrMaxDepth))
+
+}
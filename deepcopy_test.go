@@ -0,0 +1,75 @@
+package redaction
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type deepRecord struct {
+	Name     *string
+	Tags     []string
+	Labels   map[string]string
+	Any      any
+	Matrix   [2][]int
+	Child    *deepRecord
+	Users    []userRecord
+	Password *string `redact:"all=zero"`
+	private  *string
+}
+
+func TestDeepCopy(t *testing.T) {
+	newRecord := func() *deepRecord {
+		return &deepRecord{
+			Name:     strPointer("name"),
+			Tags:     []string{"a", "b"},
+			Labels:   map[string]string{"a": "b"},
+			Any:      strPointer("any"),
+			Matrix:   [2][]int{{1}, {2}},
+			Child:    &deepRecord{Name: strPointer("child"), Tags: []string{"c"}},
+			Users:    []userRecord{newUserRecord()},
+			Password: strPointer("password"),
+			private:  strPointer("private"),
+		}
+	}
+	t.Run("deep", func(t *testing.T) {
+		rec := newRecord()
+		clean, err := RedactWith(New(WithDeepCopy()), rec)
+		require.NoError(t, err)
+		require.Nil(t, clean.Password)
+		requireUserRedacted(t, false, clean.Users[0])
+		require.NotSame(t, rec.Name, clean.Name)
+		require.NotSame(t, rec.Any, clean.Any)
+		require.NotSame(t, rec.Child, clean.Child)
+		// Mutating the copy must never reach the original.
+		*clean.Name = "changed"
+		clean.Tags[0] = "changed"
+		clean.Labels["a"] = "changed"
+		*(clean.Any.(*string)) = "changed"
+		clean.Matrix[0][0] = 100
+		*clean.Child.Name = "changed"
+		clean.Child.Tags[0] = "changed"
+		*clean.Users[0].PointerTest = "changed"
+		require.Equal(t, newRecord(), rec)
+		// Unexported fields can't be copied through reflection.
+		require.Same(t, rec.private, clean.private)
+	})
+	t.Run("shallow", func(t *testing.T) {
+		rec := newRecord()
+		clean, err := RedactRecord(rec)
+		require.NoError(t, err)
+		require.Same(t, rec.Name, clean.Name)
+		require.Same(t, rec.Child.Name, clean.Child.Name)
+		// Child holds tagged fields so it's always copied.
+		require.NotSame(t, rec.Child, clean.Child)
+	})
+	t.Run("shared references", func(t *testing.T) {
+		rec := newRecord()
+		rec.Any = rec.Name
+		rec.Child.Child = rec
+		clean, err := RedactWith(New(WithDeepCopy()), rec)
+		require.NoError(t, err)
+		require.Same(t, clean.Name, clean.Any)
+		require.Same(t, clean, clean.Child.Child)
+		require.NotSame(t, rec, clean)
+	})
+}
@@ -0,0 +1,112 @@
+package redaction
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/weisbartb/redact/internal"
+	"reflect"
+	"strings"
+)
+
+// SyntaxError is returned when a tag can not be compiled, it reports the byte position of the failure.
+type SyntaxError = internal.SyntaxError
+
+// ErrAmbiguousRule is returned when a rule that follows a method chain names a method as a group,
+// e.g. remove(-4)|email|admin=zero reads email as a group rather than a chained method.
+var ErrAmbiguousRule = internal.ErrAmbiguousRule
+
+var ErrMaxDepth = errors.New("maximum redaction depth exceeded")
+
+// DepthError is returned when a record is nested deeper than the maximum depth of the redactor.
+// It matches ErrMaxDepth with errors.Is.
+type DepthError struct {
+	Limit int
+	// Type is the type of the value that exceeded the limit.
+	Type reflect.Type
+}
+
+func (e *DepthError) Error() string {
+	return fmt.Sprintf("%v: limit of %d reached at %v", ErrMaxDepth, e.Limit, e.Type)
+}
+
+func (e *DepthError) Is(target error) bool {
+	return target == ErrMaxDepth
+}
+
+// FieldError describes a redaction tag that could not be compiled or applied to a struct field.
+type FieldError struct {
+	// Type is the struct type that declares the field.
+	Type reflect.Type
+	// Field is the name of the struct field.
+	Field string
+	// Tag is the raw redaction tag on the field.
+	Tag string
+	// Pos is the byte offset into Tag the error was found at, -1 if the error was not caused by compiling the tag.
+	Pos int
+	Err error
+}
+
+func newFieldError(tOf reflect.Type, idx int, tag string, err error) *FieldError {
+	var fe = &FieldError{
+		Type:  tOf,
+		Field: tOf.Field(idx).Name,
+		Tag:   tag,
+		Pos:   -1,
+		Err:   err,
+	}
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErr) {
+		fe.Pos = syntaxErr.Pos
+		fe.Err = syntaxErr.Err
+	}
+	return fe
+}
+
+func (fe *FieldError) Error() string {
+	if fe.Pos >= 0 {
+		return fmt.Sprintf("redaction tag %q on %v.%v: %v at position %d", fe.Tag, fe.Type, fe.Field, fe.Err, fe.Pos)
+	}
+	return fmt.Sprintf("redaction tag %q on %v.%v: %v", fe.Tag, fe.Type, fe.Field, fe.Err)
+}
+
+func (fe *FieldError) Unwrap() error {
+	return fe.Err
+}
+
+// FieldErrors is a collection of field errors, returned when more than one field failed.
+type FieldErrors []*FieldError
+
+func (fe FieldErrors) Error() string {
+	var msgs = make([]string, 0, len(fe))
+	for _, err := range fe {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (fe FieldErrors) Unwrap() []error {
+	var errs = make([]error, 0, len(fe))
+	for _, err := range fe {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// orNil returns nil for an empty collection and the lone error for a single failure.
+func (fe FieldErrors) orNil() error {
+	switch len(fe) {
+	case 0:
+		return nil
+	case 1:
+		return fe[0]
+	}
+	return fe
+}
+
+func isFieldError(err error) bool {
+	switch err.(type) {
+	case *FieldError, FieldErrors:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package redaction
+
+import (
+	"context"
+	"github.com/weisbartb/redact/internal"
+)
+
+// ErrFPEKeyRequired is returned when a tag uses fpe, or DecryptFPE is called, on a redactor without WithFPEKey.
+var ErrFPEKeyRequired = internal.ErrFPEKeyRequired
+
+// ErrFPEDomainTooSmall is returned when fpe is applied to a value with too few characters of its alphabet to encrypt.
+var ErrFPEDomainTooSmall = internal.ErrFPEDomainTooSmall
+
+// ErrInvalidAlphabet is returned when the alphabet of fpe has less than two characters or repeats one.
+var ErrInvalidAlphabet = internal.ErrInvalidAlphabet
+
+// WithFPEKey sets the AES key (16, 24 or 32 bytes) the fpe method encrypts values with,
+// the authorized groups (and groups that imply them) can recover values with DecryptFPE.
+func WithFPEKey(key []byte, authorized ...string) Option {
+	return func(r *Redactor) {
+		r.fpeKey = append([]byte(nil), key...)
+		r.decryptors = append(r.decryptors, authorized...)
+	}
+}
+
+// DecryptFPE reverses the fpe method, if one of the groups is authorized by WithFPEKey.
+// The alphabet and tweak must be the arguments the value was encrypted with, an empty alphabet means "digits".
+func (r *Redactor) DecryptFPE(ciphertext, alphabet, tweak string, groups ...string) (string, error) {
+	if len(r.fpeKey) == 0 {
+		return "", ErrFPEKeyRequired
+	}
+	if err := r.authorize(groups, r.decryptors); err != nil {
+		return "", err
+	}
+	if len(alphabet) == 0 {
+		alphabet = "digits"
+	}
+	f, err := internal.NewFPE(r.fpeKey, alphabet, tweak)
+	if err != nil {
+		return "", err
+	}
+	return f.Decrypt(ciphertext)
+}
+
+// DecryptFPECtx reverses the fpe method the same way DecryptFPE does, the groups are resolved from ctx.
+func (r *Redactor) DecryptFPECtx(ctx context.Context, ciphertext, alphabet, tweak string) (string, error) {
+	groups, err := r.resolveGroups(ctx)
+	if err != nil {
+		return "", err
+	}
+	return r.DecryptFPE(ciphertext, alphabet, tweak, groups...)
+}
@@ -0,0 +1,51 @@
+package redaction
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type cardRecord struct {
+	Card    string `redact:"~billing=fpe"`
+	Account string `redact:"all=fpe(\"alphanumeric\",\"account\")"`
+}
+
+func TestFPE(t *testing.T) {
+	var key = []byte("0123456789abcdef")
+	r := New(WithFPEKey(key, "billing"))
+	require.NoError(t, r.Implies("finance", "billing"))
+	var record = cardRecord{Card: "4111111111111111", Account: "AB12-cd34"}
+	clean, err := RedactWith(r, record, "support")
+	require.NoError(t, err)
+	require.Regexp(t, `^\d{16}$`, clean.Card)
+	require.NotEqual(t, record.Card, clean.Card)
+	require.Regexp(t, `^[0-9A-Za-z]{4}-[0-9A-Za-z]{4}$`, clean.Account)
+	again, err := RedactWith(r, record, "support")
+	require.NoError(t, err)
+	require.Equal(t, clean, again)
+
+	card, err := r.DecryptFPE(clean.Card, "", "", "billing")
+	require.NoError(t, err)
+	require.Equal(t, record.Card, card)
+	account, err := r.DecryptFPECtx(WithGroups(context.Background(), "finance"), clean.Account, "alphanumeric", "account")
+	require.NoError(t, err)
+	require.Equal(t, record.Account, account)
+	// The tweak is part of the key, decrypting with the wrong one does not recover the value.
+	account, err = r.DecryptFPE(clean.Account, "alphanumeric", "", "billing")
+	require.NoError(t, err)
+	require.NotEqual(t, record.Account, account)
+	_, err = r.DecryptFPE(clean.Card, "", "", "support")
+	require.True(t, errors.Is(err, ErrUnauthorized))
+
+	clean, err = RedactWith(r, cardRecord{Card: "4111", Account: "AB12-cd34"}, "support")
+	require.True(t, errors.Is(err, ErrFPEDomainTooSmall))
+	require.Equal(t, cardRecord{}, clean)
+	_, err = RedactRecord(record)
+	require.True(t, errors.Is(err, ErrFPEKeyRequired))
+	_, err = RedactValueWith(r, "value", `all=fpe("aa")`)
+	require.True(t, errors.Is(err, ErrInvalidAlphabet))
+	_, err = New().DecryptFPE(clean.Card, "", "", "billing")
+	require.True(t, errors.Is(err, ErrFPEKeyRequired))
+}
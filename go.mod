@@ -4,6 +4,7 @@ go 1.22
 
 require (
 	github.com/pkg/errors v0.9.1
+	github.com/rivo/uniseg v0.4.7
 	github.com/stretchr/testify v1.9.0
 	github.com/weisbartb/rcache v1.0.1
 )
@@ -0,0 +1,205 @@
+package redaction
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/weisbartb/redact/internal"
+	"slices"
+	"sync"
+)
+
+var ErrUnknownGroup = errors.New("unknown group")
+var ErrUnauthorized = errors.New("groups are not authorized")
+
+// UnknownGroupError is returned when a group that has not been declared is used, it matches ErrUnknownGroup with errors.Is.
+type UnknownGroupError struct {
+	Group string
+	// Suggestion is the declared group closest to Group, it is empty if none are close.
+	Suggestion string
+}
+
+func (e *UnknownGroupError) Error() string {
+	if len(e.Suggestion) > 0 {
+		return fmt.Sprintf("%v %q, did you mean %q?", ErrUnknownGroup, e.Group, e.Suggestion)
+	}
+	return fmt.Sprintf("%v %q", ErrUnknownGroup, e.Group)
+}
+
+func (e *UnknownGroupError) Is(target error) bool {
+	return target == ErrUnknownGroup
+}
+
+// groupRegistry is a concurrency safe set of the groups known to a redactor.
+type groupRegistry struct {
+	mu        sync.RWMutex
+	known     map[string]bool
+	normalize internal.GroupNormalizer
+}
+
+func newGroupRegistry(normalize internal.GroupNormalizer) *groupRegistry {
+	return &groupRegistry{known: map[string]bool{}, normalize: normalize}
+}
+
+func (g *groupRegistry) declare(groups []string) error {
+	var normalized = make([]string, 0, len(groups))
+	for _, group := range groups {
+		group = g.normalize(group)
+		if len(group) == 0 {
+			return errors.Wrap(ErrInvalidGroupName, "group can not be empty")
+		}
+		normalized = append(normalized, group)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, group := range normalized {
+		g.known[group] = true
+	}
+	return nil
+}
+
+// enabled reports if any group has been declared, the registry is not enforced until one is.
+func (g *groupRegistry) enabled() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.known) > 0
+}
+
+// check returns an UnknownGroupError if a normalized group has not been declared.
+func (g *groupRegistry) check(group string) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if group == internal.GroupAll || g.known[group] {
+		return nil
+	}
+	var suggestion string
+	var best = -1
+	for known := range g.known {
+		distance := editDistance(group, known)
+		if distance > maxSuggestionDistance(group) || (best >= 0 && distance > best) {
+			continue
+		}
+		// Ties are broken alphabetically so the suggestion is stable
+		if distance < best || best < 0 || known < suggestion {
+			suggestion, best = known, distance
+		}
+	}
+	return &UnknownGroupError{Group: group, Suggestion: suggestion}
+}
+
+// maxSuggestionDistance is the largest edit distance a declared group can be from an unknown group to be suggested.
+func maxSuggestionDistance(group string) int {
+	if n := len([]rune(group)) / 3; n > 2 {
+		return n
+	}
+	return 2
+}
+
+// editDistance is the optimal string alignment distance between two strings,
+// the number of insertions, deletions, substitutions and transpositions of adjacent runes to turn a into b.
+func editDistance(a, b string) int {
+	var ra, rb = []rune(a), []rune(b)
+	var d = make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			var cost = 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+// DeclareGroups declares groups on the default redactor, see (*Redactor).DeclareGroups.
+func DeclareGroups(groups ...string) error {
+	return defaultRedactor.DeclareGroups(groups...)
+}
+
+// DeclareGroups declares the groups that exist, ValidateWith reports tags that name any other group.
+// With WithStrictGroups, tags that name an undeclared group fail to compile and records can only be redacted
+// for declared groups. Groups should be declared during init as tags are compiled (and cached) on first use.
+func (r *Redactor) DeclareGroups(groups ...string) error {
+	return r.groups.declare(groups)
+}
+
+// checkGroups rejects groups of a caller that have not been declared when the redactor is strict.
+func (r *Redactor) checkGroups(groups []string) error {
+	if !r.strictGroups {
+		return nil
+	}
+	for _, group := range groups {
+		if group = r.normalizeGroup(group); group == r.defaultGroup {
+			continue
+		}
+		if err := r.groups.check(group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorize checks that one of the groups of a caller, or a group they imply, is one of the authorized groups.
+func (r *Redactor) authorize(groups []string, authorized []string) error {
+	if len(groups) == 0 {
+		groups = []string{r.defaultGroup}
+	}
+	if err := r.checkGroups(groups); err != nil {
+		return err
+	}
+	for _, group := range r.roles.expand(groups) {
+		if slices.Contains(authorized, group) {
+			return nil
+		}
+	}
+	return ErrUnauthorized
+}
+
+// checkRules rejects the rules of a clause that name a group that has not been declared when the redactor is strict.
+func (r *Redactor) checkRules(tag string, clause internal.Clause, rules internal.Rules) error {
+	if !r.strictGroups {
+		return nil
+	}
+	for _, ref := range rules.Groups() {
+		if err := r.groups.check(ref.Name); err != nil {
+			return &SyntaxError{Instruction: tag, Pos: clause.Offset + ref.Pos, Err: err}
+		}
+	}
+	return nil
+}
+
+// unknownGroups returns an error for every group named in a tag that has not been declared,
+// nothing is returned if no groups have been declared.
+func (r *Redactor) unknownGroups(tag string) []error {
+	if !r.groups.enabled() {
+		return nil
+	}
+	clauses, err := internal.SplitClauses(tag)
+	if err != nil {
+		return nil
+	}
+	var errs []error
+	for _, clause := range clauses {
+		rules, err := r.methods.compile(r.newScanner(clause.Instruction))
+		if err != nil {
+			// Tags that don't compile are reported on their own
+			return nil
+		}
+		for _, ref := range rules.Groups() {
+			if err := r.groups.check(ref.Name); err != nil {
+				errs = append(errs, &SyntaxError{Instruction: tag, Pos: clause.Offset + ref.Pos, Err: err})
+			}
+		}
+	}
+	return errs
+}
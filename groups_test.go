@@ -0,0 +1,140 @@
+package redaction
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type casedRecord struct {
+	Name  string `redact:"~Admin=zero"`
+	Notes string `redact:"~[Ädmin, CSR]=zero"`
+}
+
+type typoRecord struct {
+	Name  string `redact:"~admin=zero"`
+	Notes string `redact:"~[admin,csr]=zero;adimn&all=star(1)"`
+}
+
+type strictRecord struct {
+	Name string `redact:"~[admin,csr]=zero"`
+}
+
+func TestGroupNormalization(t *testing.T) {
+	var newRecord = func() casedRecord {
+		return casedRecord{Name: "name", Notes: "notes"}
+	}
+	for _, groups := range [][]string{{"admin"}, {"ADMIN"}, {" Admin "}} {
+		clean, err := RedactRecord(newRecord(), groups...)
+		require.NoError(t, err)
+		require.Equal(t, "name", clean.Name, groups)
+	}
+	clean, err := RedactRecord(newRecord(), "csr")
+	require.NoError(t, err)
+	require.Equal(t, "", clean.Name)
+	require.Equal(t, "notes", clean.Notes)
+	// Letters outside ASCII are lower cased too.
+	clean, err = RedactRecord(newRecord(), "ÄDMIN")
+	require.NoError(t, err)
+	require.Equal(t, "notes", clean.Notes)
+	clean, err = RedactRecord(newRecord(), "ädmin")
+	require.NoError(t, err)
+	require.Equal(t, "notes", clean.Notes)
+	// Final sigma only matches sigma when folded.
+	sigma, err := RedactValue("notes", "~σ=zero", "ς")
+	require.NoError(t, err)
+	require.Equal(t, "", sigma)
+	sigma, err = RedactValueWith(New(WithUnicodeGroupFolding()), "notes", "~σ=zero", "ς")
+	require.NoError(t, err)
+	require.Equal(t, "notes", sigma)
+}
+
+func TestKnownGroups(t *testing.T) {
+	r := New(WithKnownGroups("Admin", "csr"))
+	require.NoError(t, ValidateWith[casedRecord](New(WithKnownGroups("admin", "Ädmin", "csr"))))
+	err := ValidateWith[typoRecord](r)
+	var fieldErrs FieldErrors
+	require.True(t, errors.As(err, &fieldErrs))
+	require.Len(t, fieldErrs, 1)
+	require.Equal(t, "Notes", fieldErrs[0].Field)
+	require.Equal(t, 18, fieldErrs[0].Pos)
+	require.True(t, errors.Is(err, ErrUnknownGroup))
+	// Unknown groups are only reported by Validate, the record is still redacted.
+	clean, err := RedactWith(r, typoRecord{Name: "name", Notes: "notes"}, "admin")
+	require.NoError(t, err)
+	require.Equal(t, "notes", clean.Notes)
+	// Without any known groups every group is accepted.
+	require.NoError(t, Validate[typoRecord]())
+}
+
+func TestStrictGroups(t *testing.T) {
+	r := New(WithStrictGroups())
+	require.NoError(t, r.DeclareGroups("admin", "csr"))
+	require.Error(t, r.DeclareGroups(" "))
+	t.Run("tag", func(t *testing.T) {
+		_, err := RedactWith(r, typoRecord{Name: "name", Notes: "notes"}, "admin")
+		var fieldErr *FieldError
+		require.True(t, errors.As(err, &fieldErr))
+		require.Equal(t, 18, fieldErr.Pos)
+		var unknown *UnknownGroupError
+		require.True(t, errors.As(err, &unknown))
+		require.Equal(t, "adimn", unknown.Group)
+		require.Equal(t, "admin", unknown.Suggestion)
+		require.True(t, errors.Is(err, ErrUnknownGroup))
+	})
+	t.Run("caller", func(t *testing.T) {
+		clean, err := RedactWith(r, strictRecord{Name: "name"}, "ADMIN")
+		require.NoError(t, err)
+		require.Equal(t, "name", clean.Name)
+		// The default group does not need to be declared.
+		_, err = RedactWith(r, strictRecord{Name: "name"})
+		require.NoError(t, err)
+		clean, err = RedactWith(r, strictRecord{Name: "name"}, "admin", "cs")
+		require.Equal(t, &UnknownGroupError{Group: "cs", Suggestion: "csr"}, err)
+		require.Equal(t, strictRecord{}, clean)
+		var record = strictRecord{Name: "name"}
+		err = RedactInPlaceWith(r, &record, "auditor")
+		require.Equal(t, &UnknownGroupError{Group: "auditor"}, err)
+		require.Equal(t, strictRecord{}, record)
+		_, err = RedactValueWith(r, "value", "all=zero", "amdin")
+		require.EqualError(t, err, `unknown group "amdin", did you mean "admin"?`)
+		_, err = RedactWithCtx(WithGroups(context.Background(), "scr"), r, strictRecord{})
+		require.True(t, errors.Is(err, ErrUnknownGroup))
+	})
+	t.Run("policy", func(t *testing.T) {
+		_, err := RedactValueWith(r, "value", "~[admin,cssr]=zero", "admin")
+		var syntaxErr *SyntaxError
+		require.True(t, errors.As(err, &syntaxErr))
+		require.Equal(t, 8, syntaxErr.Pos)
+		require.Equal(t, &UnknownGroupError{Group: "cssr", Suggestion: "csr"}, syntaxErr.Err)
+		_, err = RedactValueWith(r, "value", "all=zero", "admin")
+		require.NoError(t, err)
+	})
+	t.Run("validate", func(t *testing.T) {
+		var errs FieldErrors
+		require.True(t, errors.As(ValidateWith[typoRecord](r), &errs))
+		require.Len(t, errs, 1)
+		require.Equal(t, "Notes", errs[0].Field)
+		require.Equal(t, 18, errs[0].Pos)
+		require.True(t, errors.Is(errs, ErrUnknownGroup))
+	})
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		distance int
+	}{
+		{"admin", "admin", 0},
+		{"adimn", "admin", 1},
+		{"amin", "admin", 1},
+		{"admins", "admin", 1},
+		{"csr", "admin", 5},
+		{"", "csr", 3},
+		{"ädmin", "admin", 1},
+	} {
+		require.Equal(t, tc.distance, editDistance(tc.a, tc.b), tc)
+		require.Equal(t, tc.distance, editDistance(tc.b, tc.a), tc)
+	}
+}
@@ -0,0 +1,61 @@
+package redaction
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type hashedRecord struct {
+	Email   string `redact:"~admin=hash"`
+	Account string `redact:"all=hash(8,\"base64\")"`
+}
+
+func TestHash(t *testing.T) {
+	var key = []byte("analytics")
+	var record = hashedRecord{Email: "user@example.com", Account: "acct-1"}
+	t.Run("keyed", func(t *testing.T) {
+		r := New(WithHashKey(key))
+		clean, err := RedactWith(r, record, "analyst")
+		require.NoError(t, err)
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(record.Email))
+		require.Equal(t, hex.EncodeToString(mac.Sum(nil)), clean.Email)
+		require.Len(t, clean.Account, 11)
+		// Digests are stable, so redacted records can still be joined.
+		again, err := RedactWith(New(WithHashKey(key)), record, "csr")
+		require.NoError(t, err)
+		require.Equal(t, clean, again)
+		clean, err = RedactWith(r, record, "admin")
+		require.NoError(t, err)
+		require.Equal(t, record.Email, clean.Email)
+	})
+	t.Run("salted", func(t *testing.T) {
+		clean, err := RedactWith(New(WithHashSalt([]byte("salt"))), record)
+		require.NoError(t, err)
+		sum := sha256.Sum256([]byte("salt" + record.Email))
+		require.Equal(t, hex.EncodeToString(sum[:]), clean.Email)
+		other, err := RedactWith(New(WithHashKey(key), WithHashSalt([]byte("salt"))), record)
+		require.NoError(t, err)
+		require.NotEqual(t, clean.Email, other.Email)
+	})
+	t.Run("key required", func(t *testing.T) {
+		_, err := RedactRecord(record)
+		require.True(t, errors.Is(err, ErrHashKeyRequired))
+	})
+	t.Run("arguments", func(t *testing.T) {
+		r := New(WithHashKey(key))
+		for _, policy := range []string{"all=hash(33)", "all=hash(-1)", `all=hash(4,"base32")`} {
+			_, err := RedactValueWith(r, "value", policy)
+			require.Error(t, err, policy)
+		}
+		out, err := RedactValueWith(r, "value", "all=hash(4)")
+		require.NoError(t, err)
+		require.Len(t, out, 8)
+		_, err = RedactValueWith(r, 1, "all=hash")
+		require.Error(t, err)
+	})
+}
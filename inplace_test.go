@@ -0,0 +1,92 @@
+package redaction
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type sharedHashRecord struct {
+	H *string `redact:"all=hash(4)"`
+	G *string `redact:"all=hash(4)"`
+}
+
+func TestRedactInPlace(t *testing.T) {
+	t.Run("record", func(t *testing.T) {
+		for _, admin := range []bool{false, true} {
+			var groups []string
+			if admin {
+				groups = []string{"admin"}
+			}
+			rec := newUserRecord()
+			ptr := rec.PointerTest
+			nonExported := rec.nonExported
+			require.NoError(t, RedactInPlace(&rec, groups...))
+			requireUserRedacted(t, admin, rec)
+			// References are modified rather than replaced.
+			require.Same(t, ptr, rec.PointerTest)
+			require.Same(t, nonExported, rec.nonExported)
+			// The result matches a redacted copy.
+			clean, err := RedactRecord(newUserRecord(), groups...)
+			require.NoError(t, err)
+			require.Equal(t, clean, rec)
+		}
+	})
+	t.Run("collections", func(t *testing.T) {
+		recs := []userRecord{newUserRecord(), newUserRecord()}
+		backing := &recs[0]
+		require.NoError(t, RedactInPlace(&recs))
+		require.Same(t, backing, &recs[0])
+		for _, rec := range recs {
+			requireUserRedacted(t, false, rec)
+		}
+		byName := map[string]*userRecord{"a": &recs[0]}
+		require.NoError(t, RedactInPlace(&byName))
+		require.Same(t, &recs[0], byName["a"])
+	})
+	t.Run("map keys", func(t *testing.T) {
+		rec := keyedRecord{
+			ByEmail:  map[string]string{"test@test.com": "raw"},
+			KeysOnly: map[string]int{"abcdef": 1},
+		}
+		byEmail := rec.ByEmail
+		require.NoError(t, RedactInPlace(&rec))
+		require.Equal(t, map[string]string{"t************": ""}, byEmail)
+		require.Equal(t, map[string]int{"abc***": 1}, rec.KeysOnly)
+	})
+	t.Run("shared references", func(t *testing.T) {
+		// Hashing is not idempotent, a shared pointer must only be redacted once.
+		r := New(WithHashKey([]byte("key")))
+		clean, err := RedactWith(r, sharedHashRecord{H: strPointer("secret"), G: strPointer("secret")})
+		require.NoError(t, err)
+		shared := strPointer("secret")
+		rec := sharedHashRecord{H: shared, G: shared}
+		require.NoError(t, RedactInPlaceWith(r, &rec))
+		require.Equal(t, *clean.H, *rec.H)
+		require.Same(t, shared, rec.G)
+	})
+	t.Run("cycles", func(t *testing.T) {
+		head := &listNode{Value: "abc", Next: &listNode{Value: "def"}}
+		head.Next.Next = head
+		require.NoError(t, RedactInPlace(head))
+		require.Equal(t, "a**", head.Value)
+		require.Equal(t, "d**", head.Next.Value)
+		require.Same(t, head, head.Next.Next)
+	})
+	t.Run("errors", func(t *testing.T) {
+		rec := brokenRecord{Name: "name", Valid: "valid", Untagged: "untagged"}
+		err := RedactInPlace(&rec)
+		var fe *FieldError
+		require.True(t, errors.As(err, &fe))
+		require.Equal(t, brokenRecord{}, rec)
+
+		rec = brokenRecord{Name: "name", Valid: "valid", Untagged: "untagged"}
+		err = RedactInPlaceWith(New(WithErrorPolicy(FailClosed)), &rec)
+		require.Error(t, err)
+		require.Equal(t, brokenRecord{Untagged: "untagged"}, rec)
+
+		var str string
+		require.True(t, errors.Is(RedactInPlace(&str), ErrMustBeStruct))
+		require.NoError(t, RedactInPlace[userRecord](nil))
+	})
+}
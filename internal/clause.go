@@ -0,0 +1,77 @@
+package internal
+
+import "github.com/pkg/errors"
+
+var ErrUnknownQualifier = errors.New("unknown qualifier")
+
+const (
+	// TargetValue is the target of clauses that have no qualifier or the value: qualifier.
+	TargetValue = "value"
+	// TargetKey is the target of clauses with the key: qualifier, they are applied to map keys.
+	TargetKey = "key"
+)
+
+// Clause is a section of an instruction separated by ';', optionally qualified with the target it applies to.
+// Example: key:all=star(2);value:~admin=zero
+type Clause struct {
+	// Target is either TargetValue or TargetKey.
+	Target string
+	// Instruction is the clause with the qualifier removed.
+	Instruction string
+	// Offset is the byte offset of Instruction within the original instruction.
+	Offset int
+}
+
+// SplitClauses splits an instruction into its clauses, separators within quoted strings are ignored.
+// Empty clauses are dropped.
+func SplitClauses(instruction string) ([]Clause, error) {
+	var clauses []Clause
+	var start int
+	var quoted, escaped bool
+	for i := 0; i <= len(instruction); i++ {
+		if i < len(instruction) {
+			c := instruction[i]
+			switch {
+			case escaped:
+				escaped = false
+				continue
+			case c == '\\':
+				escaped = true
+				continue
+			case c == '"':
+				quoted = !quoted
+				continue
+			case c != ';' || quoted:
+				continue
+			}
+		}
+		clause, err := newClause(instruction[start:i], start)
+		if err != nil {
+			return nil, &SyntaxError{Instruction: instruction, Pos: start, Err: err}
+		}
+		if len(clause.Instruction) > 0 {
+			clauses = append(clauses, clause)
+		}
+		start = i + 1
+	}
+	return clauses, nil
+}
+
+// newClause strips the qualifier from a clause, a qualifier is an identifier followed by ':' before any other syntax.
+func newClause(text string, offset int) (Clause, error) {
+scan:
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case ':':
+			var qualifier = text[:i]
+			switch qualifier {
+			case TargetKey, TargetValue:
+				return Clause{Target: qualifier, Instruction: text[i+1:], Offset: offset + i + 1}, nil
+			}
+			return Clause{}, errors.Wrapf(ErrUnknownQualifier, "%q", qualifier)
+		case '[', ']', '"', ',', '(', ')', '=', '~', '!', '|', '&':
+			break scan
+		}
+	}
+	return Clause{Target: TargetValue, Instruction: text, Offset: offset}, nil
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSplitClauses(t *testing.T) {
+	tests := []struct {
+		name        string
+		instruction string
+		want        []Clause
+	}{
+		{
+			name:        "single",
+			instruction: "~admin=star(2)",
+			want:        []Clause{{Target: TargetValue, Instruction: "~admin=star(2)", Offset: 0}},
+		},
+		{
+			name:        "qualified",
+			instruction: "key:all=star(2);value:~admin=zero",
+			want: []Clause{
+				{Target: TargetKey, Instruction: "all=star(2)", Offset: 4},
+				{Target: TargetValue, Instruction: "~admin=zero", Offset: 22},
+			},
+		},
+		{
+			name:        "quoted separators",
+			instruction: `all=redact(";","key:")`,
+			want:        []Clause{{Target: TargetValue, Instruction: `all=redact(";","key:")`, Offset: 0}},
+		},
+		{
+			name:        "escaped quote",
+			instruction: `all=redact("\";");key:all=zero`,
+			want: []Clause{
+				{Target: TargetValue, Instruction: `all=redact("\";")`, Offset: 0},
+				{Target: TargetKey, Instruction: "all=zero", Offset: 22},
+			},
+		},
+		{
+			name:        "empty clauses",
+			instruction: ";all=zero;",
+			want:        []Clause{{Target: TargetValue, Instruction: "all=zero", Offset: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitClauses(tt.instruction)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+	t.Run("unknown qualifier", func(t *testing.T) {
+		_, err := SplitClauses("all=zero;keys:all=zero")
+		var syntaxErr *SyntaxError
+		require.True(t, errors.As(err, &syntaxErr))
+		require.Equal(t, 9, syntaxErr.Pos)
+		require.True(t, errors.Is(err, ErrUnknownQualifier))
+	})
+}
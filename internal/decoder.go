@@ -1,41 +1,78 @@
 package internal
 
-import "bytes"
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+var ErrUnexpectedCharacter = errors.New("unexpected character")
+var ErrUnterminated = errors.New("unterminated sequence")
+
+// token is a decoded value along with the byte offset it started at.
+type token struct {
+	value string
+	pos   int
+}
 
 // setDecoder handles both parameters and set decoding [] ().
 // There was no reason to separate them into two different methods.
 type setDecoder struct {
 	*scanner
 	activeBuffer *bytes.Buffer
-	tokens       []string
+	tokens       []token
+	// closer is the character that is expected to terminate the set.
+	closer byte
 }
 
-func (d setDecoder) decode() []string {
+func (d setDecoder) decode() []token {
+	var start = -1
+	var flush = func() {
+		// Whitespace around tokens is insignificant, e.g. [admin, csr]
+		value := strings.TrimLeft(d.activeBuffer.String(), " \t")
+		pos := start + d.activeBuffer.Len() - len(value)
+		if value = strings.TrimRight(value, " \t"); len(value) > 0 {
+			d.tokens = append(d.tokens, token{value: value, pos: pos})
+		}
+		d.activeBuffer.Reset()
+		start = -1
+	}
+	var opened = d.pos - 1
 	for {
 		c, stop := d.nextChar()
 		if stop {
 			switch c {
-			case 0, ']', ')':
-				if d.activeBuffer.Len() > 0 {
-					d.tokens = append(d.tokens, d.activeBuffer.String())
-					d.activeBuffer.Reset()
+			case 0:
+				d.fail(opened, errors.Wrapf(ErrUnterminated, "missing %q", d.closer))
+				flush()
+				return d.tokens
+			case ']', ')':
+				if c != d.closer {
+					d.fail(d.pos-1, errors.Wrapf(ErrUnexpectedCharacter, "%q", c))
 				}
+				flush()
 				return d.tokens
 			case ',':
-				if d.activeBuffer.Len() > 0 {
-					d.tokens = append(d.tokens, d.activeBuffer.String())
-					d.activeBuffer.Reset()
-				}
+				flush()
 				continue
 			case '"':
-				d.tokens = append(d.tokens, stringDecoder{scanner: d.scanner, activeBuffer: &bytes.Buffer{}}.decode())
-			case '~':
+				pos := d.pos - 1
+				d.tokens = append(d.tokens, token{
+					value: stringDecoder{scanner: d.scanner, activeBuffer: &bytes.Buffer{}}.decode(),
+					pos:   pos,
+				})
+			case '~', '!':
+				if start < 0 {
+					start = d.pos - 1
+				}
 				d.activeBuffer.WriteByte(c)
 			default:
-				// Default skips through the character
-				// Probably want to return an error in a future refactoring
+				d.fail(d.pos-1, errors.Wrapf(ErrUnexpectedCharacter, "%q", c))
 			}
 		} else {
+			if start < 0 {
+				start = d.pos - 1
+			}
 			d.activeBuffer.WriteByte(c)
 		}
 	}
@@ -52,10 +89,15 @@ func (d stringDecoder) decode() string {
 	var prevByte2 byte
 	// Write a " to force it to a quote, tokens that don't use string decoder can't use "'s as a legal character.
 	d.activeBuffer.WriteByte('"')
+	var opened = d.pos - 1
 	for {
 		c, stop := d.nextChar()
 		if stop {
-			if (c == '"' && prevByte != '\\') || c == 0 {
+			if c == 0 {
+				d.fail(opened, errors.Wrap(ErrUnterminated, "missing closing quote"))
+				return d.activeBuffer.String()
+			}
+			if c == '"' && prevByte != '\\' {
 				// Flush out the string if we hit the end of the string and an escape wasn't provided
 				return d.activeBuffer.String()
 			}
@@ -0,0 +1,273 @@
+package internal
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"unicode"
+)
+
+// GroupAll is the group identifier that matches every caller.
+const GroupAll = "all"
+
+// expr is a node of the group expression on the left side of a rule.
+// Precedence from highest to lowest is !/~, & then |, sets are the | of their members.
+type expr interface {
+	// eval reports if the expression is satisfied by the target groups.
+	eval(targetGroups []string) bool
+	// references appends the groups named by the expression
+	references(dst []GroupRef) []GroupRef
+	String() string
+}
+
+type groupExpr struct {
+	identifier string
+	// pos is the byte offset of the identifier in the instruction
+	pos int
+}
+
+func (e groupExpr) eval(targetGroups []string) bool {
+	return e.identifier == GroupAll || containsGroup(targetGroups, e.identifier)
+}
+
+func (e groupExpr) references(dst []GroupRef) []GroupRef {
+	if e.identifier == GroupAll {
+		return dst
+	}
+	return append(dst, GroupRef{Name: e.identifier, Pos: e.pos})
+}
+
+func (e groupExpr) String() string {
+	return e.identifier
+}
+
+type notExpr struct {
+	x expr
+}
+
+func (e notExpr) eval(targetGroups []string) bool {
+	return !e.x.eval(targetGroups)
+}
+
+func (e notExpr) references(dst []GroupRef) []GroupRef {
+	return e.x.references(dst)
+}
+
+func (e notExpr) String() string {
+	return "!" + joinExprs([]expr{e.x}, "")
+}
+
+type andExpr []expr
+
+func (e andExpr) eval(targetGroups []string) bool {
+	for _, x := range e {
+		if !x.eval(targetGroups) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e andExpr) references(dst []GroupRef) []GroupRef {
+	for _, x := range e {
+		dst = x.references(dst)
+	}
+	return dst
+}
+
+func (e andExpr) String() string {
+	return joinExprs(e, " & ")
+}
+
+type orExpr []expr
+
+func (e orExpr) eval(targetGroups []string) bool {
+	for _, x := range e {
+		if x.eval(targetGroups) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e orExpr) references(dst []GroupRef) []GroupRef {
+	for _, x := range e {
+		dst = x.references(dst)
+	}
+	return dst
+}
+
+func (e orExpr) String() string {
+	return joinExprs(e, " | ")
+}
+
+func joinExprs(exprs []expr, sep string) string {
+	var parts = make([]string, 0, len(exprs))
+	for _, x := range exprs {
+		switch x.(type) {
+		case andExpr, orExpr:
+			parts = append(parts, "("+x.String()+")")
+		default:
+			parts = append(parts, x.String())
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+// containsGroup reports if a group identifier is one of the target groups.
+// Both are expected to have been normalized with the same GroupNormalizer.
+func containsGroup(targetGroups []string, identifier string) bool {
+	for _, targetGroup := range targetGroups {
+		if targetGroup == identifier {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupRef is a group named in an instruction.
+type GroupRef struct {
+	// Name is the normalized group identifier.
+	Name string
+	// Pos is the byte offset of the identifier in the instruction.
+	Pos int
+}
+
+// GroupNormalizer maps a group identifier to the form it is compared in.
+type GroupNormalizer func(group string) string
+
+// NormalizeGroup is the default GroupNormalizer, surrounding whitespace is trimmed and letters are lower cased.
+func NormalizeGroup(group string) string {
+	return strings.ToLower(strings.TrimSpace(group))
+}
+
+// FoldGroup is a GroupNormalizer that applies Unicode simple case folding, so "ÄDMIN" matches "ädmin".
+// Surrounding whitespace is trimmed.
+func FoldGroup(group string) string {
+	return strings.Map(foldRune, strings.TrimSpace(group))
+}
+
+// foldRune maps a rune to the lower case form of the smallest rune it folds to.
+func foldRune(r rune) rune {
+	var folded = r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < folded {
+			folded = f
+		}
+	}
+	return unicode.ToLower(folded)
+}
+
+// negate wraps x in a notExpr if inverse is set.
+func negate(x expr, inverse bool) expr {
+	if inverse {
+		return notExpr{x: x}
+	}
+	return x
+}
+
+// groupParser is a recursive descent parser for the group expression of a rule.
+// It consumes ops until the '=' of the rule.
+type groupParser struct {
+	ris *InstructionScanner
+	op  *op
+}
+
+// group creates a group expression for an identifier, normalized with the normalizer of the scanner.
+func (gp *groupParser) group(identifier string, pos int) groupExpr {
+	return groupExpr{identifier: gp.ris.normalizeGroup(identifier), pos: pos}
+}
+
+// parse parses a full expression, the parser is left on the run op that ends it.
+func (gp *groupParser) parse() (expr, error) {
+	x, err := gp.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case gp.op == nil:
+		return nil, gp.ris.syntaxError(len(gp.ris.instruction), errors.Wrap(ErrInvalidOpChain, "missing '='"))
+	case gp.op.opCode == opCodeClose:
+		return nil, gp.ris.syntaxError(gp.op.pos, errors.Wrapf(ErrUnexpectedCharacter, "%q", ')'))
+	case gp.op.opCode != opCodeRun:
+		return nil, gp.ris.syntaxError(gp.op.pos, errors.Wrapf(ErrInvalidOpChain, "expected an operator, found %v", gp.op.opCode))
+	}
+	return x, nil
+}
+
+func (gp *groupParser) parseOr() (expr, error) {
+	var terms orExpr
+	for {
+		x, err := gp.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, x)
+		if gp.op == nil || gp.op.opCode != opCodeOr {
+			break
+		}
+		gp.op = gp.op.next
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (gp *groupParser) parseAnd() (expr, error) {
+	var terms andExpr
+	for {
+		x, err := gp.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, x)
+		if gp.op == nil || gp.op.opCode != opCodeAnd {
+			break
+		}
+		gp.op = gp.op.next
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (gp *groupParser) parsePrimary() (expr, error) {
+	var o = gp.op
+	if o == nil {
+		return nil, gp.ris.syntaxError(len(gp.ris.instruction), errors.Wrap(ErrInvalidOpChain, "missing group"))
+	}
+	switch o.opCode {
+	case opCodeString:
+		gp.op = o.next
+		return negate(gp.group(o.value.(string), o.pos), o.inverse), nil
+	case opCodeSet:
+		var members = make(orExpr, 0, len(o.children))
+		for _, v := range o.children {
+			identifier, ok := v.value.(string)
+			if !ok || v.opCode != opCodeString {
+				return nil, gp.ris.syntaxError(v.pos, errors.Wrapf(ErrInvalidGroup, "%v is not a valid group", v.opCode))
+			}
+			members = append(members, negate(gp.group(identifier, v.pos), v.inverse))
+		}
+		gp.op = o.next
+		if len(members) == 1 {
+			return negate(members[0], o.inverse), nil
+		}
+		return negate(members, o.inverse), nil
+	case opCodeOpen:
+		gp.op = o.next
+		x, err := gp.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if gp.op == nil || gp.op.opCode != opCodeClose {
+			return nil, gp.ris.syntaxError(o.pos, errors.Wrapf(ErrUnterminated, "missing %q", ')'))
+		}
+		gp.op = gp.op.next
+		return negate(x, o.inverse), nil
+	case opCodeRun, opCodeAnd, opCodeOr, opCodeClose:
+		return nil, gp.ris.syntaxError(o.pos, errors.Wrap(ErrInvalidOpChain, "missing group"))
+	}
+	return nil, gp.ris.syntaxError(o.pos, errors.Wrapf(ErrInvalidGroup, "%v is not a valid group", o.opCode))
+}
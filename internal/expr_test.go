@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func parseGroups(t *testing.T, instruction string) expr {
+	t.Helper()
+	ris := NewInstructionScanner(instruction)
+	require.NoError(t, ris.Scan())
+	parser := groupParser{ris: ris, op: ris.firstOp}
+	x, err := parser.parse()
+	require.NoError(t, err)
+	return x
+}
+
+func TestGroupParser(t *testing.T) {
+	tests := []struct {
+		instruction string
+		want        string
+	}{
+		{instruction: "admin=zero", want: "admin"},
+		{instruction: "~admin=zero", want: "!admin"},
+		{instruction: "!admin=zero", want: "!admin"},
+		{instruction: "~Admin=zero", want: "!admin"},
+		{instruction: "!!admin=zero", want: "admin"},
+		{instruction: "[admin,csr]=zero", want: "admin | csr"},
+		{instruction: "~[admin,csr]=zero", want: "!(admin | csr)"},
+		{instruction: "[~admin,csr]=zero", want: "!admin | csr"},
+		{instruction: "[!admin, csr]=zero", want: "!admin | csr"},
+		{instruction: "(support & !eu)|auditor=star(4)", want: "(support & !eu) | auditor"},
+		{instruction: "support & !eu | auditor=star(4)", want: "(support & !eu) | auditor"},
+		{instruction: "a | b & c=zero", want: "a | (b & c)"},
+		{instruction: "!(a|b) & c=zero", want: "!(a | b) & c"},
+		{instruction: "~(a & [b,c])=zero", want: "!(a & (b | c))"},
+		{instruction: `( "a b" )=zero`, want: "a b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.instruction, func(t *testing.T) {
+			require.Equal(t, tt.want, parseGroups(t, tt.instruction).String())
+		})
+	}
+}
+
+func TestGroupExpressionEval(t *testing.T) {
+	x := parseGroups(t, "(support & !eu)|auditor=star(4)")
+	tests := []struct {
+		groups []string
+		want   bool
+	}{
+		{groups: nil, want: false},
+		{groups: []string{"support"}, want: true},
+		{groups: []string{"support", "eu"}, want: false},
+		{groups: []string{"eu"}, want: false},
+		{groups: []string{"auditor", "eu"}, want: true},
+		{groups: []string{"auditor", "support", "eu"}, want: true},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, x.eval(tt.groups), "%v", tt.groups)
+	}
+	require.True(t, parseGroups(t, "all=zero").eval(nil))
+	require.False(t, parseGroups(t, "~all=zero").eval([]string{"admin"}))
+}
+
+func TestNormalizeGroup(t *testing.T) {
+	require.Equal(t, "admin", NormalizeGroup(" ADMIN "))
+	require.Equal(t, "ädmin", NormalizeGroup(" ÄDMIN "))
+	// Letters that only match when folded, such as the final sigma, need FoldGroup.
+	require.NotEqual(t, NormalizeGroup("σ"), NormalizeGroup("ς"))
+	require.Equal(t, "ädmin", FoldGroup("ÄDMIN"))
+	require.Equal(t, FoldGroup("straße"), FoldGroup("STRAẞE"))
+	require.Equal(t, FoldGroup("kelvin"), FoldGroup("Kelvin"))
+	require.Equal(t, FoldGroup("σς"), FoldGroup("ΣΣ"))
+
+	ris := NewInstructionScanner("~ÄDMIN=zero").NormalizeGroups(FoldGroup)
+	rules, err := ris.GetRules(map[string]RawMethod{"zero": MethodZero})
+	require.NoError(t, err)
+	require.Equal(t, []GroupRef{{Name: "ädmin", Pos: 1}}, rules.Groups())
+	idx, matched := rules.Match([]string{FoldGroup("Ädmin")})
+	require.True(t, matched)
+	require.Equal(t, Exempt, idx)
+}
@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"math/big"
+)
+
+var ErrFPEKeyRequired = errors.New("fpe requires a key")
+var ErrInvalidAlphabet = errors.New("invalid alphabet")
+var ErrFPEDomainTooSmall = errors.New("value is too short to encrypt")
+
+// fpeRounds is the number of Feistel rounds of FF1.
+const fpeRounds = 10
+
+// fpeMinDomain is the smallest number of values FF1 is allowed to encrypt, radix^length must be at least this.
+const fpeMinDomain = 1000000
+
+// FPEAlphabets are the alphabets that can be referred to by name, any other alphabet is read literally.
+var FPEAlphabets = map[string]string{
+	"digits":       "0123456789",
+	"alphanumeric": "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+}
+
+// FPE encrypts the characters of a string that are in an alphabet with FF1 (NIST SP 800-38G) over AES,
+// the ciphertext has the same length and only uses characters of the alphabet.
+// Characters outside the alphabet, such as separators, are left in place and are not encrypted.
+type FPE struct {
+	block    cipher.Block
+	alphabet []rune
+	index    map[rune]int
+	tweak    []byte
+}
+
+// NewFPE creates an FPE with an AES-128, AES-192 or AES-256 key, an alphabet (or the name of one) and a tweak.
+func NewFPE(key []byte, alphabet string, tweak string) (*FPE, error) {
+	if len(key) == 0 {
+		return nil, ErrFPEKeyRequired
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating fpe cipher")
+	}
+	if named, ok := FPEAlphabets[alphabet]; ok {
+		alphabet = named
+	}
+	var f = &FPE{block: block, alphabet: []rune(alphabet), index: map[rune]int{}, tweak: []byte(tweak)}
+	if len(f.alphabet) < 2 || len(f.alphabet) > 1<<16 {
+		return nil, errors.Wrapf(ErrInvalidAlphabet, "%q must have between 2 and 65536 characters", alphabet)
+	}
+	for i, r := range f.alphabet {
+		if _, ok := f.index[r]; ok {
+			return nil, errors.Wrapf(ErrInvalidAlphabet, "%q repeats %q", alphabet, r)
+		}
+		f.index[r] = i
+	}
+	return f, nil
+}
+
+// NewMethodFPE creates the fpe method bound to a key.
+// The method takes two optional arguments, the alphabet ("digits" by default, "alphanumeric" or the characters of
+// a custom alphabet) and a tweak, which should differ between fields that must not share ciphertexts.
+// The encrypted characters must have at least a million possible values, e.g. 6 digits.
+// Example: 4111-1111-1111-1111 with fpe("digits","card") would result in something like 7302-5941-0866-2175
+func NewMethodFPE(key []byte) RawMethod {
+	return func(arguments ...Arg) (MemoizedMethod, error) {
+		var alphabet, tweak = "digits", ""
+		if len(arguments) > 0 {
+			alphabet = arguments[0].String()
+		}
+		if len(arguments) > 1 {
+			tweak = arguments[1].String()
+		}
+		f, err := NewFPE(key, alphabet, tweak)
+		if err != nil {
+			return nil, err
+		}
+		return func(value any) error {
+			vOf, err := StringValueOf(value)
+			if err != nil {
+				return errors.Wrap(err, "in redaction method fpe")
+			}
+			out, err := f.Encrypt(vOf.String())
+			if err != nil {
+				return errors.Wrap(err, "in redaction method fpe")
+			}
+			vOf.SetString(out)
+			return nil
+		}, nil
+	}
+}
+
+// Encrypt encrypts the characters of value that are in the alphabet.
+func (f *FPE) Encrypt(value string) (string, error) {
+	return f.transform(value, true)
+}
+
+// Decrypt reverses Encrypt.
+func (f *FPE) Decrypt(value string) (string, error) {
+	return f.transform(value, false)
+}
+
+func (f *FPE) transform(value string, encrypt bool) (string, error) {
+	var runes = []rune(value)
+	var positions []int
+	var numerals []int
+	for i, r := range runes {
+		if n, ok := f.index[r]; ok {
+			positions = append(positions, i)
+			numerals = append(numerals, n)
+		}
+	}
+	var domain = new(big.Int).Exp(big.NewInt(int64(len(f.alphabet))), big.NewInt(int64(len(numerals))), nil)
+	if len(numerals) < 2 || domain.Cmp(big.NewInt(fpeMinDomain)) < 0 {
+		return "", errors.Wrapf(ErrFPEDomainTooSmall, "%d characters of a %d character alphabet", len(numerals), len(f.alphabet))
+	}
+	numerals = f.ff1(numerals, encrypt)
+	for i, pos := range positions {
+		runes[pos] = f.alphabet[numerals[i]]
+	}
+	return string(runes), nil
+}
+
+// ff1 encrypts or decrypts a numeral string, following algorithms 7 and 8 of NIST SP 800-38G.
+func (f *FPE) ff1(x []int, encrypt bool) []int {
+	var radix = len(f.alphabet)
+	var n, t = len(x), len(f.tweak)
+	var u = n / 2
+	var v = n - u
+	var bigRadix = big.NewInt(int64(radix))
+	var modU = new(big.Int).Exp(bigRadix, big.NewInt(int64(u)), nil)
+	var modV = new(big.Int).Exp(bigRadix, big.NewInt(int64(v)), nil)
+	var b = (new(big.Int).Sub(modV, big.NewInt(1)).BitLen() + 7) / 8
+	var d = 4*((b+3)/4) + 4
+	var pad = (16 - (t+b+1)%16) % 16
+
+	var pq = make([]byte, 16+t+pad+1+b)
+	copy(pq, []byte{1, 2, 1, byte(radix >> 16), byte(radix >> 8), byte(radix), 10, byte(u)})
+	binary.BigEndian.PutUint32(pq[8:], uint32(n))
+	binary.BigEndian.PutUint32(pq[12:], uint32(t))
+	copy(pq[16:], f.tweak)
+	var round = pq[16+t+pad:]
+
+	var a, bNum = num(x[:u], bigRadix), num(x[u:], bigRadix)
+	var s = make([]byte, (d+15)/16*16)
+	var y, c big.Int
+	for r := 0; r < fpeRounds; r++ {
+		var i, numeral = r, bNum
+		if !encrypt {
+			i, numeral = fpeRounds-1-r, a
+		}
+		round[0] = byte(i)
+		clear(round[1:])
+		numeral.FillBytes(round[1:])
+		f.prf(s[:16], pq)
+		for j := 1; j < len(s)/16; j++ {
+			var block = s[j*16 : (j+1)*16]
+			copy(block, s[:16])
+			var counter [16]byte
+			binary.BigEndian.PutUint64(counter[8:], uint64(j))
+			for k := range block {
+				block[k] ^= counter[k]
+			}
+			f.block.Encrypt(block, block)
+		}
+		y.SetBytes(s[:d])
+		var mod = modU
+		if i%2 == 1 {
+			mod = modV
+		}
+		if encrypt {
+			c.Add(a, &y)
+			c.Mod(&c, mod)
+			a, bNum = bNum, new(big.Int).Set(&c)
+		} else {
+			c.Sub(bNum, &y)
+			c.Mod(&c, mod)
+			bNum, a = a, new(big.Int).Set(&c)
+		}
+	}
+	return append(str(a, u, radix), str(bNum, v, radix)...)
+}
+
+// prf is the CBC-MAC of data with a zero IV, data must be a multiple of the block size.
+func (f *FPE) prf(dst, data []byte) {
+	clear(dst)
+	for i := 0; i < len(data); i += 16 {
+		for k := 0; k < 16; k++ {
+			dst[k] ^= data[i+k]
+		}
+		f.block.Encrypt(dst, dst)
+	}
+}
+
+// num is the number a numeral string represents, the first numeral is the most significant.
+func num(x []int, radix *big.Int) *big.Int {
+	var out = new(big.Int)
+	for _, n := range x {
+		out.Mul(out, radix)
+		out.Add(out, big.NewInt(int64(n)))
+	}
+	return out
+}
+
+// str is the numeral string of length m that represents a number.
+func str(x *big.Int, m int, radix int) []int {
+	var out = make([]int, m)
+	var rest, digit = new(big.Int).Set(x), new(big.Int)
+	var bigRadix = big.NewInt(int64(radix))
+	for i := m - 1; i >= 0; i-- {
+		rest.DivMod(rest, bigRadix, digit)
+		out[i] = int(digit.Int64())
+	}
+	return out
+}
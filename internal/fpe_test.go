@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestFPE(t *testing.T) {
+	// Samples from the NIST FF1 examples for SP 800-38G
+	tests := []struct {
+		name       string
+		key        string
+		alphabet   string
+		tweak      string
+		plaintext  string
+		ciphertext string
+	}{
+		{
+			name:       "sample 1",
+			key:        "2B7E151628AED2A6ABF7158809CF4F3C",
+			alphabet:   "digits",
+			plaintext:  "0123456789",
+			ciphertext: "2433477484",
+		},
+		{
+			name:       "sample 2",
+			key:        "2B7E151628AED2A6ABF7158809CF4F3C",
+			alphabet:   "digits",
+			tweak:      "9876543210",
+			plaintext:  "0123456789",
+			ciphertext: "6124200773",
+		},
+		{
+			name:       "sample 3",
+			key:        "2B7E151628AED2A6ABF7158809CF4F3C",
+			alphabet:   "0123456789abcdefghijklmnopqrstuvwxyz",
+			tweak:      "7777pqrs777",
+			plaintext:  "0123456789abcdefghi",
+			ciphertext: "a9tv40mll9kdu509eum",
+		},
+		{
+			name:       "sample 4",
+			key:        "2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F",
+			alphabet:   "digits",
+			plaintext:  "0123456789",
+			ciphertext: "2830668132",
+		},
+		{
+			name:       "sample 7",
+			key:        "2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94",
+			alphabet:   "digits",
+			plaintext:  "0123456789",
+			ciphertext: "6657667009",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := hex.DecodeString(tt.key)
+			require.NoError(t, err)
+			f, err := NewFPE(key, tt.alphabet, tt.tweak)
+			require.NoError(t, err)
+			out, err := f.Encrypt(tt.plaintext)
+			require.NoError(t, err)
+			require.Equal(t, tt.ciphertext, out)
+			out, err = f.Decrypt(tt.ciphertext)
+			require.NoError(t, err)
+			require.Equal(t, tt.plaintext, out)
+		})
+	}
+	t.Run("format", func(t *testing.T) {
+		f, err := NewFPE(make([]byte, 16), "digits", "card")
+		require.NoError(t, err)
+		out, err := f.Encrypt("4111-1111-1111-1111")
+		require.NoError(t, err)
+		require.Regexp(t, `^\d{4}-\d{4}-\d{4}-\d{4}$`, out)
+		require.NotEqual(t, "4111-1111-1111-1111", out)
+		out, err = f.Decrypt(out)
+		require.NoError(t, err)
+		require.Equal(t, "4111-1111-1111-1111", out)
+		// Odd lengths split the numerals unevenly
+		out, err = f.Encrypt("1234567")
+		require.NoError(t, err)
+		require.Len(t, out, 7)
+		out, err = f.Decrypt(out)
+		require.NoError(t, err)
+		require.Equal(t, "1234567", out)
+	})
+	t.Run("errors", func(t *testing.T) {
+		_, err := NewFPE(nil, "digits", "")
+		require.True(t, errors.Is(err, ErrFPEKeyRequired))
+		_, err = NewFPE(make([]byte, 15), "digits", "")
+		require.Error(t, err)
+		for _, alphabet := range []string{"", "a", "abca"} {
+			_, err = NewFPE(make([]byte, 16), alphabet, "")
+			require.True(t, errors.Is(err, ErrInvalidAlphabet), alphabet)
+		}
+		f, err := NewFPE(make([]byte, 16), "digits", "")
+		require.NoError(t, err)
+		for _, value := range []string{"12345", "1", "abc-def"} {
+			_, err = f.Encrypt(value)
+			require.True(t, errors.Is(err, ErrFPEDomainTooSmall), value)
+		}
+	})
+}
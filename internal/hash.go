@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"hash"
+)
+
+var ErrHashKeyRequired = errors.New("hash requires a key or salt")
+
+// hashEncodings maps the encodings accepted by the hash method to their encoders.
+var hashEncodings = map[string]func([]byte) string{
+	"hex":    hex.EncodeToString,
+	"base64": base64.RawURLEncoding.EncodeToString,
+}
+
+// NewMethodHash creates the hash method bound to a key and salt, the salt is prepended to the value before hashing.
+// With a key the digest is HMAC-SHA-256, without one it is SHA-256. Unsalted, unkeyed digests can be reversed
+// by hashing every likely value, so at least one of the two is required.
+// The method takes two optional arguments, the number of bytes of the digest to keep (all 32 by default)
+// and the encoding, "hex" (the default) or "base64" (URL safe, without padding).
+// Example: hash(8,"base64") would result in an 11 character identifier
+func NewMethodHash(key, salt []byte) RawMethod {
+	return func(arguments ...Arg) (MemoizedMethod, error) {
+		if len(key) == 0 && len(salt) == 0 {
+			return nil, ErrHashKeyRequired
+		}
+		var size = sha256.Size
+		if len(arguments) > 0 {
+			if n := arguments[0].Int(); n != 0 {
+				size = n
+			}
+		}
+		if size < 1 || size > sha256.Size {
+			return nil, errors.Wrapf(ErrInvalidArgument, "hash length must be between 1 and %d", sha256.Size)
+		}
+		var encode = hashEncodings["hex"]
+		if len(arguments) > 1 {
+			var ok bool
+			if encode, ok = hashEncodings[arguments[1].String()]; !ok {
+				return nil, errors.Wrapf(ErrInvalidArgument, "unknown hash encoding %q", arguments[1].String())
+			}
+		}
+		var newHash = sha256.New
+		if len(key) > 0 {
+			newHash = func() hash.Hash {
+				return hmac.New(sha256.New, key)
+			}
+		}
+		return func(value any) error {
+			vOf, err := StringValueOf(value)
+			if err != nil {
+				return errors.Wrap(err, "in redaction method hash")
+			}
+			h := newHash()
+			h.Write(salt)
+			h.Write([]byte(vOf.String()))
+			vOf.SetString(encode(h.Sum(nil)[:size]))
+			return nil
+		}, nil
+	}
+}
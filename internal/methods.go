@@ -2,89 +2,192 @@ package internal
 
 import (
 	"github.com/pkg/errors"
+	"github.com/rivo/uniseg"
+	"math"
 	"reflect"
 	"strings"
 )
 
+// MemoizedMethod is a compiled method, it is cached and called concurrently so it must never modify the state it captures.
 type MemoizedMethod func(value any) error
 type RawMethod func(arguments ...Arg) (MemoizedMethod, error)
 
 var ErrValueCanOnlyBeString = errors.New("value can only be a string")
 var ErrValueMustBeReference = errors.New("value must be a reference")
 
-func getStringValueOf(value any) (reflect.Value, error) {
+// ValueOf resolves the value handed to a MemoizedMethod into a settable reflect.Value.
+// Values are either a settable reflect.Value (as passed by the redactor) or a pointer to the value.
+func ValueOf(value any) (reflect.Value, error) {
 	vOf, ok := value.(reflect.Value)
 	if !ok {
 		vOf = reflect.ValueOf(value)
-		if vOf.Kind() != reflect.Ptr {
+		if vOf.Kind() != reflect.Ptr || vOf.IsNil() {
 			return reflect.Value{}, ErrValueMustBeReference
 		}
 		vOf = vOf.Elem()
-		if vOf.Kind() != reflect.String {
-			return reflect.Value{}, ErrValueCanOnlyBeString
-		}
+	}
+	if !vOf.CanSet() {
+		return reflect.Value{}, ErrValueMustBeReference
+	}
+	return vOf, nil
+}
+
+// StringValueOf resolves the value the same way ValueOf does, but also requires the underlying kind to be a string.
+func StringValueOf(value any) (reflect.Value, error) {
+	vOf, err := ValueOf(value)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if vOf.Kind() != reflect.String {
+		return reflect.Value{}, ErrValueCanOnlyBeString
 	}
 	return vOf, nil
 }
 
-// MethodStar will asterisk (*) characters.
+// graphemes splits a string into its grapheme clusters, the characters a reader sees.
+// An emoji made of several code points joined with ZWJ, or a letter followed by combining marks, is a single cluster.
+func graphemes(str string) []string {
+	var out = make([]string, 0, len(str))
+	var state = -1
+	for len(str) > 0 {
+		var cluster string
+		cluster, str, _, state = uniseg.FirstGraphemeClusterInString(str, state)
+		out = append(out, cluster)
+	}
+	return out
+}
+
+// fitMode controls how remove and star handle values that are not longer than their offset.
+type fitMode int
+
+const (
+	// fitClamp limits the offset to the length of the value, the whole value is kept.
+	fitClamp fitMode = iota
+	// fitPad pads the value with * to the length of the offset, on the left for negative offsets.
+	fitPad
+	// fitMask stars every character, so short values are never revealed in full.
+	fitMask
+)
+
+// fitModes maps the names accepted as the mode argument of remove and star to their mode.
+var fitModes = map[string]fitMode{
+	"clamp": fitClamp,
+	"pad":   fitPad,
+	"mask":  fitMask,
+}
+
+// offsetArg reads the offset from the first argument, it is kept above math.MinInt so it can always be negated.
+func offsetArg(arguments []Arg) int {
+	if len(arguments) == 0 {
+		return 0
+	}
+	return max(arguments[0].Int(), -math.MaxInt)
+}
+
+// maxPadding is the largest offset that can be used with the pad mode, so a tag can't allocate unbounded padding.
+const maxPadding = 1024
+
+// fitModeArg reads the mode from the second argument, defaulting to fitClamp.
+func fitModeArg(arguments []Arg, offset int) (fitMode, error) {
+	if len(arguments) < 2 {
+		return fitClamp, nil
+	}
+	mode, ok := fitModes[arguments[1].String()]
+	if !ok {
+		return fitClamp, errors.Wrapf(ErrInvalidArgument, "unknown mode %q, expected clamp, pad or mask", arguments[1].String())
+	}
+	if mode == fitPad && (offset > maxPadding || offset < -maxPadding) {
+		return fitClamp, errors.Wrapf(ErrInvalidArgument, "offsets padded with pad can not exceed %d", maxPadding)
+	}
+	return mode, nil
+}
+
+// fit applies the mode to the characters of a value that has no more characters than the offset.
+func fit(chars []string, offset int, mode fitMode) []string {
+	var n = offset
+	if n < 0 {
+		n = -n
+	}
+	if len(chars) > n {
+		return chars
+	}
+	switch mode {
+	case fitPad:
+		var pad = make([]string, n-len(chars))
+		for i := range pad {
+			pad[i] = "*"
+		}
+		if offset < 0 {
+			return append(pad, chars...)
+		}
+		return append(chars, pad...)
+	case fitMask:
+		for i := range chars {
+			chars[i] = "*"
+		}
+	}
+	return chars
+}
+
+// MethodStar will asterisk (*) characters, a character is a grapheme cluster.
 // Takes a single argument (integer) that if positive, it will star the first X characters.
 // If negative, it will star the last X characters
+// The second argument is the mode used for values that are not longer than the offset, clamp (default), pad or mask.
 // Example 555-555-5555 with remove(-4) would be ********5555
 func MethodStar(arguments ...Arg) (MemoizedMethod, error) {
-	var offset int
-	if len(arguments) > 0 {
-		offset = arguments[0].Int()
+	var offset = offsetArg(arguments)
+	mode, err := fitModeArg(arguments, offset)
+	if err != nil {
+		return nil, err
 	}
 	return func(value any) error {
-		vOf, err := getStringValueOf(value)
+		vOf, err := StringValueOf(value)
 		if err != nil {
 			return errors.Wrap(err, "in redaction method star")
 		}
+		var chars = fit(graphemes(vOf.String()), offset, mode)
 		if offset >= 0 {
-			var i int
-			vOf.SetString(strings.Map(func(r rune) rune {
-				i++
-				if i >= offset+1 {
-					return '*'
+			for i := range chars {
+				if i >= offset {
+					chars[i] = "*"
 				}
-				return r
-			}, vOf.String()))
+			}
 		} else {
-			offset *= -1
-			var i = len(vOf.String())
-			vOf.SetString(strings.Map(func(r rune) rune {
-				i--
-				if i-offset < 0 {
-					return r
+			var keep = -offset
+			for i := range chars {
+				if i < len(chars)-keep {
+					chars[i] = "*"
 				}
-				return '*'
-			}, vOf.String()))
+			}
 		}
+		vOf.SetString(strings.Join(chars, ""))
 		return nil
 	}, nil
 }
 
-// MethodRemove will remove characters from a string.
+// MethodRemove will remove characters (grapheme clusters) from a string.
 // It takes a single argument that if positive, it will remove characters after the offset.
 // If the argument is negative, it will remove everything but the last X characters.
+// The second argument is the mode used for values that are not longer than the offset, clamp (default), pad or mask.
 // Example 555-555-5555 with remove(-4) would be 5555
 func MethodRemove(arguments ...Arg) (MemoizedMethod, error) {
-	var offset int
-	if len(arguments) > 0 {
-		offset = arguments[0].Int()
+	var offset = offsetArg(arguments)
+	mode, err := fitModeArg(arguments, offset)
+	if err != nil {
+		return nil, err
 	}
 	return func(value any) error {
-		vOf, err := getStringValueOf(value)
+		vOf, err := StringValueOf(value)
 		if err != nil {
 			return errors.Wrap(err, "in redaction method remove")
 		}
+		var chars = fit(graphemes(vOf.String()), offset, mode)
 		if offset >= 0 {
-			vOf.SetString(vOf.String()[0:offset])
+			vOf.SetString(strings.Join(chars[0:min(offset, len(chars))], ""))
 		} else {
-			offset *= -1
-			var i = len(vOf.String())
-			vOf.SetString(vOf.String()[i-offset : i])
+			var keep = -offset
+			var i = len(chars)
+			vOf.SetString(strings.Join(chars[max(i-keep, 0):i], ""))
 		}
 		return nil
 	}, nil
@@ -94,51 +197,110 @@ func MethodRemove(arguments ...Arg) (MemoizedMethod, error) {
 // Example: 66 with zero() will be 0, "66" with zero() will be ""
 func MethodZero(arguments ...Arg) (MemoizedMethod, error) {
 	return func(value any) error {
-		vOf, ok := value.(reflect.Value)
-		if !ok {
-			vOf := reflect.ValueOf(value)
-			if vOf.Kind() != reflect.Ptr {
-				return errors.Wrap(ErrValueMustBeReference, "in redaction method zero")
-			}
-			vOf = vOf.Elem()
+		vOf, err := ValueOf(value)
+		if err != nil {
+			return errors.Wrap(err, "in redaction method zero")
 		}
 		vOf.Set(reflect.New(vOf.Type()).Elem())
 		return nil
 	}, nil
 }
 
-// MethodRedact allows the redaction of all characters.
-// Optionally takes a redaction character as the first argument, defaults to *.
+// MethodRedact allows the redaction of all characters (grapheme clusters).
+// Optionally takes a redaction string as the first argument that replaces each character, defaults to *.
 // The second argument is a list of allowed characters (that won't be redacted)
 // Example, 555-555-555 using redact("*","-") would result in ***-***-****
 func MethodRedact(arguments ...Arg) (MemoizedMethod, error) {
-	var allowedChars []byte
-	var redactionChar byte
-	if len(arguments) == 1 {
-		redactionChar = []byte(arguments[0].String())[0]
-	} else if len(arguments) >= 2 {
-		redactionChar = []byte(arguments[0].String())[0]
-		allowedChars = []byte(arguments[1].String())
+	var allowedChars = map[string]bool{}
+	var redactionChar string
+	if len(arguments) > 0 {
+		redactionChar = arguments[0].String()
+	}
+	if len(arguments) > 1 {
+		for _, char := range graphemes(arguments[1].String()) {
+			allowedChars[char] = true
+		}
 	}
-	if redactionChar == 0 {
-		redactionChar = '*'
+	if len(redactionChar) == 0 {
+		redactionChar = "*"
 	}
 	return func(value any) error {
-		vOf, err := getStringValueOf(value)
+		vOf, err := StringValueOf(value)
 		if err != nil {
 			return errors.Wrap(err, "in redaction method redact")
 		}
-		var out = []byte(vOf.String())
-	replace:
-		for k, v := range out {
-			for _, allowedChar := range allowedChars {
-				if v == allowedChar {
-					continue replace
+		var chars = graphemes(vOf.String())
+		for k, char := range chars {
+			if !allowedChars[char] {
+				chars[k] = redactionChar
+			}
+		}
+		vOf.SetString(strings.Join(chars, ""))
+		return nil
+	}, nil
+}
+
+// emailModes are the ways the email method can handle the domain of an address.
+var emailModes = map[string]bool{
+	"tld":    true,
+	"domain": true,
+	"mask":   true,
+}
+
+// maskPart stars the characters of part after the first keep, at least one character is always starred.
+func maskPart(part string, keep int) string {
+	var chars = graphemes(part)
+	keep = min(keep, len(chars)-1)
+	for i := max(keep, 0); i < len(chars); i++ {
+		chars[i] = "*"
+	}
+	return strings.Join(chars, "")
+}
+
+// MethodEmail masks an email address, a character is a grapheme cluster.
+// The first argument is the number of characters to keep at the start of the local part (and each masked label
+// of the domain), defaults to 1. At least one character of each part is always masked.
+// The second argument is how the domain is handled, tld (default) masks every label but the last,
+// domain keeps the domain and mask masks every label. Dots in the domain are kept.
+// Values that are not an address (no @, or an empty local part or domain) are masked completely.
+// Example jane@example.com with email would result in j***@e******.com
+func MethodEmail(arguments ...Arg) (MemoizedMethod, error) {
+	var keep, mode = 1, "tld"
+	if len(arguments) > 0 {
+		keep = arguments[0].Int()
+	}
+	if keep < 0 {
+		return nil, errors.Wrap(ErrInvalidArgument, "email can not keep a negative number of characters")
+	}
+	if len(arguments) > 1 {
+		mode = arguments[1].String()
+	}
+	if !emailModes[mode] {
+		return nil, errors.Wrapf(ErrInvalidArgument, "unknown mode %q, expected tld, domain or mask", mode)
+	}
+	return func(value any) error {
+		vOf, err := StringValueOf(value)
+		if err != nil {
+			return errors.Wrap(err, "in redaction method email")
+		}
+		var address = vOf.String()
+		// Quoted local parts may contain @, the domain never does
+		var at = strings.LastIndexByte(address, '@')
+		if at <= 0 || at == len(address)-1 {
+			vOf.SetString(maskPart(address, 0))
+			return nil
+		}
+		var domain = address[at+1:]
+		if mode != "domain" {
+			var labels = strings.Split(domain, ".")
+			for i, label := range labels {
+				if mode == "mask" || i < len(labels)-1 || len(labels) == 1 {
+					labels[i] = maskPart(label, keep)
 				}
 			}
-			out[k] = redactionChar
+			domain = strings.Join(labels, ".")
 		}
-		vOf.SetString(string(out))
+		vOf.SetString(maskPart(address[:at], keep) + "@" + domain)
 		return nil
 	}, nil
 }
@@ -269,3 +269,42 @@ func TestInstructionScanner_RedactEvaluator(t *testing.T) {
 	})
 
 }
+
+func TestInstructionScanner_ChainEvaluator(t *testing.T) {
+	var methods = map[string]internal.RawMethod{
+		"zero":   internal.MethodZero,
+		"star":   internal.MethodStar,
+		"remove": internal.MethodRemove,
+		"redact": internal.MethodRedact,
+	}
+	t.Run("pipe", func(t *testing.T) {
+		scanner := internal.NewInstructionScanner(`all=remove(7)|redact(*,"-")|star(1)`)
+		eval, err := scanner.GetEvaluator(methods)
+		require.NoError(t, err)
+		var tStr = "555-555-1234"
+		ok, err := eval(&tStr, "user")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "*******", tStr)
+	})
+	t.Run("pipe then rule", func(t *testing.T) {
+		scanner := internal.NewInstructionScanner("csr=remove(3)|star(1)|~admin=zero")
+		eval, err := scanner.GetEvaluator(methods)
+		require.NoError(t, err)
+		var tStr = "555-555-1234"
+		var tStr2 = "555-555-1234"
+		var tStr3 = "555-555-1234"
+		ok, err := eval(&tStr, "csr")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "5**", tStr)
+		ok, err = eval(&tStr2, "user")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "", tStr2)
+		ok, err = eval(&tStr3, "admin")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "555-555-1234", tStr3)
+	})
+}
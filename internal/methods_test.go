@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+	"unicode/utf8"
+)
+
+// unicodeCorpus covers text where bytes, runes and the characters a reader sees differ.
+var unicodeCorpus = []struct {
+	name  string
+	value string
+	// graphemes is the value split into grapheme clusters
+	graphemes []string
+}{
+	{"ascii", "555-1234", []string{"5", "5", "5", "-", "1", "2", "3", "4"}},
+	{"precomposed", "José", []string{"J", "o", "s", "é"}},
+	{"combining marks", "José", []string{"J", "o", "s", "é"}},
+	{"stacked marks", "ạ̈b", []string{"ạ̈", "b"}},
+	{"cjk", "山田太郎", []string{"山", "田", "太", "郎"}},
+	{"hangul jamo", "한글", []string{"한", "글"}},
+	{"emoji zwj", "a👩‍👩‍👧b", []string{"a", "👩‍👩‍👧", "b"}},
+	{"emoji modifier", "👍🏽ok", []string{"👍🏽", "o", "k"}},
+	{"flags", "🇯🇵🇫🇷", []string{"🇯🇵", "🇫🇷"}},
+	{"crlf", "a\r\nb", []string{"a", "\r\n", "b"}},
+	{"empty", "", []string{}},
+}
+
+func applyMethod(t *testing.T, method RawMethod, value string, arguments ...Arg) string {
+	t.Helper()
+	memoized, err := method(arguments...)
+	require.NoError(t, err)
+	require.NoError(t, memoized(&value))
+	require.True(t, utf8.ValidString(value), value)
+	return value
+}
+
+func intArg(i int) Arg {
+	return Arg{OpCode: opCodeInt, Value: i}
+}
+
+func stringArg(s string) Arg {
+	return Arg{OpCode: opCodeString, Value: s}
+}
+
+func TestGraphemes(t *testing.T) {
+	for _, tt := range unicodeCorpus {
+		require.Equal(t, tt.graphemes, graphemes(tt.value), tt.name)
+	}
+}
+
+func TestStringMethodsUnicode(t *testing.T) {
+	for _, tt := range unicodeCorpus {
+		t.Run(tt.name, func(t *testing.T) {
+			var n = len(tt.graphemes)
+			var keep = min(n, 1)
+			var stars = func(count int) string {
+				var out string
+				for i := 0; i < count; i++ {
+					out += "*"
+				}
+				return out
+			}
+			var join = func(chars []string) string {
+				var out string
+				for _, char := range chars {
+					out += char
+				}
+				return out
+			}
+			require.Equal(t, stars(n), applyMethod(t, MethodRedact, tt.value))
+			require.Equal(t, stars(n), applyMethod(t, MethodStar, tt.value, intArg(0)))
+			require.Equal(t, join(tt.graphemes[:keep])+stars(n-keep), applyMethod(t, MethodStar, tt.value, intArg(keep)))
+			require.Equal(t, stars(n-keep)+join(tt.graphemes[n-keep:]), applyMethod(t, MethodStar, tt.value, intArg(-keep)))
+			require.Equal(t, join(tt.graphemes[:keep]), applyMethod(t, MethodRemove, tt.value, intArg(keep)))
+			require.Equal(t, join(tt.graphemes[n-keep:]), applyMethod(t, MethodRemove, tt.value, intArg(-keep)))
+		})
+	}
+}
+
+func TestMethodRedactUnicode(t *testing.T) {
+	require.Equal(t, "••••", applyMethod(t, MethodRedact, "José", stringArg("•")))
+	require.Equal(t, "[x][x]", applyMethod(t, MethodRedact, "山田", stringArg("[x]")))
+	require.Equal(t, "🔒🔒-🔒", applyMethod(t, MethodRedact, "👩‍👩‍👧é-b", stringArg("🔒"), stringArg("-")))
+	// Allowed characters are matched as whole characters, e is not allowed to pass through é.
+	require.Equal(t, "****e", applyMethod(t, MethodRedact, "Josée", stringArg(""), stringArg("e")))
+	require.Equal(t, "J**é", applyMethod(t, MethodRedact, "José", stringArg("*"), stringArg("Jé")))
+}
+
+func TestShortValues(t *testing.T) {
+	tests := []struct {
+		method RawMethod
+		offset int
+		mode   string
+		value  string
+		want   string
+	}{
+		{MethodRemove, 4, "", "abc", "abc"},
+		{MethodRemove, -4, "", "abc", "abc"},
+		{MethodRemove, 4, "clamp", "", ""},
+		{MethodRemove, 4, "pad", "abc", "abc*"},
+		{MethodRemove, -4, "pad", "abc", "*abc"},
+		{MethodRemove, -4, "pad", "", "****"},
+		{MethodRemove, 4, "mask", "abc", "***"},
+		{MethodRemove, -3, "mask", "abc", "***"},
+		{MethodRemove, -3, "mask", "abcd", "bcd"},
+		{MethodStar, 4, "", "abc", "abc"},
+		{MethodStar, -4, "", "abc", "abc"},
+		{MethodStar, 4, "pad", "abc", "abc*"},
+		{MethodStar, -4, "pad", "山田", "**山田"},
+		{MethodStar, 4, "mask", "abcd", "****"},
+		{MethodStar, -4, "mask", "abcde", "*bcde"},
+		{MethodStar, 0, "mask", "", ""},
+		{MethodRemove, math.MinInt, "", "abc", "abc"},
+		{MethodStar, math.MinInt, "", "abc", "abc"},
+	}
+	for _, tt := range tests {
+		var arguments = []Arg{intArg(tt.offset)}
+		if len(tt.mode) > 0 {
+			arguments = append(arguments, stringArg(tt.mode))
+		}
+		require.Equal(t, tt.want, applyMethod(t, tt.method, tt.value, arguments...), tt)
+	}
+	_, err := MethodStar(intArg(1), stringArg("wrap"))
+	require.True(t, errors.Is(err, ErrInvalidArgument))
+	_, err = MethodRemove(intArg(-maxPadding-1), stringArg("pad"))
+	require.True(t, errors.Is(err, ErrInvalidArgument))
+}
+
+func TestMethodEmail(t *testing.T) {
+	tests := []struct {
+		keep  int
+		mode  string
+		value string
+		want  string
+	}{
+		{1, "", "jane@example.com", "j***@e******.com"},
+		{1, "", "j@example.com", "*@e******.com"},
+		{2, "", "jo@mail.example.co.uk", "j*@ma**.ex*****.c*.uk"},
+		{0, "", "jane@example.com", "****@*******.com"},
+		{3, "domain", "jane@example.com", "jan*@example.com"},
+		{1, "mask", "jane@example.com", "j***@e******.c**"},
+		{1, "", "jane@localhost", "j***@l********"},
+		{1, "", `"a@b"@example.com`, `"****@e******.com`},
+		{1, "", "José@bücher.de", "J***@b*****.de"},
+		{1, "", "not an email", "************"},
+		{1, "", "@example.com", "************"},
+		{1, "", "jane@", "*****"},
+		{1, "", "", ""},
+	}
+	for _, tt := range tests {
+		var arguments = []Arg{intArg(tt.keep)}
+		if len(tt.mode) > 0 {
+			arguments = append(arguments, stringArg(tt.mode))
+		}
+		require.Equal(t, tt.want, applyMethod(t, MethodEmail, tt.value, arguments...), tt)
+	}
+	require.Equal(t, "j***@e******.com", applyMethod(t, MethodEmail, "jane@example.com"))
+	_, err := MethodEmail(intArg(-1))
+	require.True(t, errors.Is(err, ErrInvalidArgument))
+	_, err = MethodEmail(intArg(1), stringArg("subdomain"))
+	require.True(t, errors.Is(err, ErrInvalidArgument))
+}
+
+// fuzzVault is a minimal TokenVault for fuzzing tokenize.
+type fuzzVault struct{}
+
+func (fuzzVault) Tokenize(value string) (string, error) {
+	return "tok", nil
+}
+
+func (fuzzVault) Detokenize(token string) (string, error) {
+	return "", nil
+}
+
+func FuzzMethods(f *testing.F) {
+	var methods = map[string]RawMethod{
+		"zero":     MethodZero,
+		"remove":   MethodRemove,
+		"star":     MethodStar,
+		"redact":   MethodRedact,
+		"email":    MethodEmail,
+		"hash":     NewMethodHash([]byte("key"), nil),
+		"tokenize": NewMethodTokenize(fuzzVault{}),
+		"fpe":      NewMethodFPE(make([]byte, 16)),
+	}
+	for _, tt := range unicodeCorpus {
+		f.Add(tt.value, 4, "", "")
+		f.Add(tt.value, -4, "pad", "-")
+	}
+	f.Add("abc", math.MinInt, "mask", "")
+	f.Add("1234567", math.MaxInt, "digits", "")
+	f.Add("\xff\xfe", -1, "\xff", "\x00")
+	f.Fuzz(func(t *testing.T, value string, offset int, text string, extra string) {
+		var arguments = []Arg{intArg(offset), stringArg(text), stringArg(extra)}
+		for name, method := range methods {
+			for n := 0; n <= len(arguments); n++ {
+				memoized, err := method(arguments[:n]...)
+				if err != nil {
+					continue
+				}
+				var out, again = value, value
+				_ = memoized(&out)
+				if utf8.ValidString(value) && utf8.ValidString(text) && !utf8.ValidString(out) {
+					t.Fatalf("%s(%v) produced invalid UTF-8 from %q: %q", name, arguments[:n], value, out)
+				}
+				// Compiled methods are cached, a second call must not see any state left by the first.
+				_ = memoized(&again)
+				if out != again {
+					t.Fatalf("%s(%v) redacted %q to %q and then %q", name, arguments[:n], value, out, again)
+				}
+			}
+		}
+	})
+}
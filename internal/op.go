@@ -13,6 +13,10 @@ const (
 	opCodeRun
 	opCodeNil
 	opCodeChain
+	opCodeAnd
+	opCodeOr
+	opCodeOpen
+	opCodeClose
 )
 
 func (o opCode) String() string {
@@ -37,6 +41,14 @@ func (o opCode) String() string {
 		return "nil"
 	case opCodeChain:
 		return "chain"
+	case opCodeAnd:
+		return "and"
+	case opCodeOr:
+		return "or"
+	case opCodeOpen:
+		return "open"
+	case opCodeClose:
+		return "close"
 	}
 	return "unknown"
 }
@@ -48,21 +60,20 @@ type op struct {
 	children []*op
 	next     *op
 	// string, float64, bool, int
+	// chains hold true when they separate rules rather than methods
 	value any
+	// pos is the byte offset of the op in the instruction
+	pos int
 }
 
 // Next transverses the linked list of ops till a matching opcode is encountered.
 func (o *op) Next(codes ...opCode) (*op, bool) {
-	n := o
-	for {
-		if n.next == nil {
-			return nil, false
-		}
+	for n := o; n != nil; n = n.next {
 		for _, code := range codes {
 			if n.opCode == code {
 				return n, true
 			}
 		}
-		n = n.next
 	}
+	return nil, false
 }
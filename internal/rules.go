@@ -0,0 +1,105 @@
+package internal
+
+// Exempt is the index returned by Match when no rule is applied to the target groups.
+const Exempt = -1
+
+// Rules is a compiled instruction, an ordered list of group expressions and the methods applied when they are satisfied.
+type Rules []Rule
+
+// Rule is a single group expression and the method applied to a value when it is satisfied.
+type Rule struct {
+	groups expr
+	// references are the groups named by the expression, all is not included
+	references []GroupRef
+	// runOnNoMatch is set when the rule is satisfied by a caller without any groups, e.g. ~admin or all
+	runOnNoMatch bool
+	MemoizedMethod
+}
+
+func newRule(groups expr, method MemoizedMethod) Rule {
+	return Rule{
+		groups:         groups,
+		references:     groups.references(nil),
+		runOnNoMatch:   groups.eval(nil),
+		MemoizedMethod: method,
+	}
+}
+
+// names reports if the expression of the rule names one of the target groups.
+func (r *Rule) names(targetGroups []string) bool {
+	for _, ref := range r.references {
+		if containsGroup(targetGroups, ref.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the index of the first rule whose group expression is satisfied by the target groups.
+// A rule that applies to everyone except the target groups exempts them, Exempt is returned and no later rule is checked.
+// matched reports if any rule was applied or exempted the target groups.
+func (rs Rules) Match(targetGroups []string) (idx int, matched bool) {
+	return rs.match(defaultGroups(targetGroups), func(*Rule) bool { return true })
+}
+
+// MatchMostSpecific selects a rule the same way Match does, except rules that name one of the target groups are checked
+// before rules that only apply to them through all or a negation.
+func (rs Rules) MatchMostSpecific(targetGroups []string) (idx int, matched bool) {
+	targetGroups = defaultGroups(targetGroups)
+	if idx, matched = rs.match(targetGroups, func(r *Rule) bool { return r.names(targetGroups) }); matched {
+		return idx, matched
+	}
+	return rs.match(targetGroups, func(r *Rule) bool { return !r.names(targetGroups) })
+}
+
+func (rs Rules) match(targetGroups []string, include func(r *Rule) bool) (int, bool) {
+	for i := range rs {
+		if !include(&rs[i]) {
+			continue
+		}
+		if rs[i].groups.eval(targetGroups) {
+			return i, true
+		}
+		if rs[i].runOnNoMatch {
+			return Exempt, true
+		}
+	}
+	return Exempt, false
+}
+
+// Groups returns every group named by the rules, in the order they are written.
+func (rs Rules) Groups() []GroupRef {
+	var refs []GroupRef
+	for _, rule := range rs {
+		refs = append(refs, rule.references...)
+	}
+	return refs
+}
+
+// Apply runs the method of a rule against a value.
+func (rs Rules) Apply(idx int, value any) error {
+	return rs[idx].MemoizedMethod(value)
+}
+
+// Evaluator memoizes the rules into an evaluator that applies the rule selected by Match.
+// The target groups are normalized with NormalizeGroup.
+func (rs Rules) Evaluator() Evaluator {
+	return func(value any, targetGroups ...string) (bool, error) {
+		var normalized = make([]string, 0, len(targetGroups))
+		for _, group := range targetGroups {
+			normalized = append(normalized, NormalizeGroup(group))
+		}
+		idx, matched := rs.Match(normalized)
+		if idx == Exempt {
+			return matched, nil
+		}
+		return true, rs.Apply(idx, value)
+	}
+}
+
+func defaultGroups(targetGroups []string) []string {
+	if len(targetGroups) == 0 {
+		return []string{"none"}
+	}
+	return targetGroups
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/pkg/errors"
 	"strconv"
 	"strings"
@@ -10,11 +11,113 @@ import (
 type scanner struct {
 	instruction []byte
 	pos         int
+	// err is the first syntax error encountered while scanning
+	err *SyntaxError
+}
+
+// SyntaxError describes a failure to scan or compile an instruction.
+type SyntaxError struct {
+	Instruction string
+	// Pos is the byte offset into the instruction where the error was found.
+	Pos int
+	Err error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%v at position %d of %q", e.Err, e.Pos, e.Instruction)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// fail records a syntax error at the given position, only the first error is retained.
+func (s *scanner) fail(pos int, err error) {
+	if s.err != nil {
+		return
+	}
+	s.err = s.syntaxError(pos, err)
+}
+
+func (s *scanner) syntaxError(pos int, err error) *SyntaxError {
+	return &SyntaxError{
+		Instruction: string(s.instruction),
+		Pos:         pos,
+		Err:         err,
+	}
 }
 
 var ErrIllegalOpCode = errors.New("illegal opcode")
 var ErrNoMatchingTransformer = errors.New("no matching transformer found")
 var ErrInvalidArgument = errors.New("invalid argument")
+var ErrMissingMethod = errors.New("missing method")
+var ErrInvalidGroup = errors.New("invalid group identifier")
+var ErrAmbiguousRule = errors.New("rule names a method as a group")
+
+// ruleFollows reports if the instruction after a chain is the start of a new rule rather than a method.
+// The group expression of a rule (identifiers, sets, parentheses and operators) is skipped, a rule follows if it is
+// ended by an '='. An identifier followed by parameters is a method, as is anything that reaches the end of the instruction.
+func (s *scanner) ruleFollows() bool {
+	// ident is set when the last token was an identifier, a '(' after it opens the parameters of a method
+	var ident bool
+	for i := s.pos; i < len(s.instruction); i++ {
+		switch c := s.instruction[i]; c {
+		case '=':
+			return true
+		case '(':
+			if ident {
+				return false
+			}
+		case '"':
+			i = s.skipQuoted(i)
+			ident = true
+			continue
+		case '[':
+			for i++; i < len(s.instruction) && s.instruction[i] != ']'; i++ {
+				if s.instruction[i] == '"' {
+					i = s.skipQuoted(i)
+				}
+			}
+			ident = true
+			continue
+		case ' ', '\t':
+			continue
+		case ')', '&', '|', '!', '~':
+		default:
+			if isSyntaxChar(c) {
+				return false
+			}
+			ident = true
+			continue
+		}
+		ident = false
+	}
+	return false
+}
+
+// skipQuoted returns the position of the quote that closes the string opened at i.
+func (s *scanner) skipQuoted(i int) int {
+	var escaped bool
+	for i++; i < len(s.instruction); i++ {
+		switch c := s.instruction[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			return i
+		}
+	}
+	return i
+}
+
+func isSyntaxChar(c byte) bool {
+	switch c {
+	case '[', ']', '"', ',', '(', ')', '=', '~', '!', '|', '&':
+		return true
+	}
+	return false
+}
 
 func (s *scanner) nextChar() (c byte, stop bool) {
 	if s.pos >= len(s.instruction) {
@@ -22,11 +125,7 @@ func (s *scanner) nextChar() (c byte, stop bool) {
 	}
 	c = s.instruction[s.pos]
 	s.pos++
-	switch c {
-	case '[', ']', '"', ',', '(', ')', '=', '~', '|':
-		return c, true
-	}
-	return c, false
+	return c, isSyntaxChar(c)
 }
 
 // InstructionScanner is a public struct that should be created with NewInstructionScanner.
@@ -36,6 +135,10 @@ type InstructionScanner struct {
 	currentOp *op
 	*scanner
 	inverseNextOp bool
+	// methodSide is set between the '=' of a rule and the end of the rule, it decides how operators are scanned
+	methodSide bool
+	// normalizeGroup is applied to every group identifier in the instruction
+	normalizeGroup GroupNormalizer
 }
 
 func (ris *InstructionScanner) setOp(op *op) {
@@ -55,10 +158,11 @@ func (ris *InstructionScanner) nextInverse() bool {
 	return false
 }
 
-func (ris *InstructionScanner) genericTokenToOpCode(token string) op {
+func (ris *InstructionScanner) genericTokenToOpCode(token string, pos int) op {
 	if len(token) == 0 {
 		return op{
 			opCode: opCodeNil,
+			pos:    pos,
 		}
 	}
 	var isString bool
@@ -84,7 +188,7 @@ func (ris *InstructionScanner) genericTokenToOpCode(token string) op {
 	var err error
 	if isString {
 		normalizedToken := strings.ToLower(token)
-		if len(token) > 0 && token[0] == '~' {
+		if len(token) > 0 && (token[0] == '~' || token[0] == '!') {
 			inverse = true
 			token = token[1:]
 		}
@@ -123,190 +227,252 @@ func (ris *InstructionScanner) genericTokenToOpCode(token string) op {
 		opCode:  code,
 		inverse: inverse,
 		value:   value,
+		pos:     pos,
 	}
 }
 
-var ErrInvalidOpChain = errors.New("invalid operation chain")
-
-type group struct {
-	identifier string
-	inverse    bool
+// decodeSet decodes a set or parameter list into ops.
+func (ris *InstructionScanner) decodeSet(closer byte) []*op {
+	return mapFilter(
+		setDecoder{
+			scanner:      ris.scanner,
+			activeBuffer: &bytes.Buffer{},
+			closer:       closer,
+		}.decode(),
+		func(v token) (*op, bool) {
+			o := ris.genericTokenToOpCode(v.value, v.pos)
+			return &o, true
+		})
 }
 
-type rule struct {
-	groups       []group
-	runOnNoMatch bool
-	MemoizedMethod
-}
+var ErrInvalidOpChain = errors.New("invalid operation chain")
 
 // GetEvaluator gets a memoized rule chain evaluator that can be called
 // Note: if Scan has not been called first, it will be called by this method
 func (ris *InstructionScanner) GetEvaluator(methodTable map[string]RawMethod) (Evaluator, error) {
-	var parsedRules []rule
+	rules, err := ris.GetRules(methodTable)
+	if err != nil {
+		return nil, err
+	}
+	return rules.Evaluator(), nil
+}
+
+// GetRules compiles the instruction into its rules.
+// Note: if Scan has not been called first, it will be called by this method
+func (ris *InstructionScanner) GetRules(methodTable map[string]RawMethod) (Rules, error) {
+	var parsedRules Rules
 	if ris.firstOp == nil {
 		ris.Scan()
 	}
+	if ris.err != nil {
+		return nil, ris.err
+	}
 	var activeOp = ris.firstOp
 	for activeOp != nil {
-		var groups []group
-		groupOp, found := activeOp.Next(opCodeSet, opCodeString)
-		if !found {
-			if len(parsedRules) == 0 {
-				return nil, ErrInvalidOpChain
+		if len(parsedRules) > 0 {
+			// Rules are separated by a chain
+			if activeOp.opCode != opCodeChain {
+				return nil, ris.syntaxError(activeOp.pos, errors.Wrapf(ErrInvalidOpChain, "unexpected %v", activeOp.opCode))
 			}
-			break
+			activeOp = activeOp.next
 		}
-		switch groupOp.opCode {
-		case opCodeString:
-			groups = append(groups, group{
-				identifier: groupOp.value.(string),
-				inverse:    groupOp.inverse,
-			})
-		case opCodeSet:
-			for _, v := range groupOp.children {
-				var inverse = groupOp.inverse
-				if v.inverse {
-					inverse = true
-				}
-				groups = append(groups, group{
-					identifier: v.value.(string),
-					inverse:    inverse,
-				})
-			}
-		default:
-			return nil, ErrInvalidOpChain
+		parser := groupParser{ris: ris, op: activeOp}
+		groups, err := parser.parse()
+		if err != nil {
+			return nil, err
 		}
-		runOp, found := groupOp.Next(opCodeRun)
-		if !found {
-			return nil, ErrInvalidOpChain
+		if len(parsedRules) > 0 {
+			if err := ris.checkChainedRule(methodTable, groups); err != nil {
+				return nil, err
+			}
 		}
-		methodIdentifier := runOp.next
-		t, ok := methodTable[methodIdentifier.value.(string)]
-		if !ok {
-			return nil, errors.Wrapf(ErrNoMatchingTransformer, "transformer for %s not found", runOp.value)
+		runOp := parser.op
+		memoedFunction, next, err := ris.compileChain(methodTable, runOp)
+		if err != nil {
+			return nil, err
 		}
-		var args []Arg
-		if methodIdentifier.next != nil && methodIdentifier.next.opCode == opCodeParams {
-			params := methodIdentifier.next
-			for _, arg := range params.children {
-				switch arg.opCode {
-				case opCodeNil, opCodeString, opCodeFloat, opCodeBool, opCodeInt:
-					args = append(args, Arg{OpCode: arg.opCode, Value: arg.value})
-				default:
-					return nil, errors.Wrapf(ErrInvalidArgument, "%v is not a valid opcode for an argument", arg.opCode.String())
-				}
+		activeOp = next
+		parsedRules = append(parsedRules, newRule(groups, memoedFunction))
+	}
+	return parsedRules, nil
+}
+
+// checkChainedRule rejects a rule that follows a method chain and names a method as a group.
+// e.g. in all=remove(-4)|email|admin=zero, email is read as a group rather than a chained method.
+func (ris *InstructionScanner) checkChainedRule(methodTable map[string]RawMethod, groups expr) error {
+	for _, ref := range groups.references(nil) {
+		for name := range methodTable {
+			if ris.normalizeGroup(name) == ref.Name {
+				return ris.syntaxError(ref.Pos, errors.Wrapf(ErrAmbiguousRule,
+					"%q after a chain is read as a group, chain the method as %s() or separate the rules with ;", ref.Name, name))
 			}
-			activeOp = params.next
-		} else {
-			activeOp = methodIdentifier.next
 		}
-		memoedFunction, err := t(args...)
+	}
+	return nil
+}
+
+// compileChain compiles the methods that follow the run op of a rule, methods separated by a chain are applied in order.
+// It returns the op after the last method, this is either nil or the chain to the next rule.
+func (ris *InstructionScanner) compileChain(methodTable map[string]RawMethod, runOp *op) (MemoizedMethod, *op, error) {
+	var stages []MemoizedMethod
+	var activeOp = runOp
+	for {
+		method, next, err := ris.compileMethod(methodTable, activeOp)
 		if err != nil {
-			return nil, errors.Wrapf(err, "could not compile method for rule %v", string(ris.instruction))
+			return nil, nil, err
 		}
-		parsedRules = append(parsedRules, rule{
-			groups:         groups,
-			runOnNoMatch:   groupOp.inverse,
-			MemoizedMethod: memoedFunction,
-		})
+		stages = append(stages, method)
+		activeOp = next
+		if next == nil || next.opCode != opCodeChain || next.value == true {
+			break
+		}
+	}
+	if len(stages) == 1 {
+		return stages[0], activeOp, nil
 	}
-	// Memoize the instruction into an evaluator
-	return func(value any, targetGroups ...string) (bool, error) {
-		if len(targetGroups) == 0 {
-			targetGroups = []string{"none"}
+	return func(value any) error {
+		for _, stage := range stages {
+			if err := stage(value); err != nil {
+				return err
+			}
 		}
-		for _, targetGroup := range targetGroups {
-			targetGroup = strings.ToLower(targetGroup)
-			for _, v := range parsedRules {
-				runOnNoMatch := v.runOnNoMatch
-				for _, group := range v.groups {
-					var err error
-					if group.identifier == targetGroup {
-						if !group.inverse {
-							err = v.MemoizedMethod(value)
-						}
-						return true, err
-					} else if group.inverse || group.identifier == "all" {
-						runOnNoMatch = true
-					}
-				}
-				if runOnNoMatch {
-					err := v.MemoizedMethod(value)
-					return true, err
-				}
+		return nil
+	}, activeOp, nil
+}
+
+// compileMethod compiles the method (and its parameters) that follows a run or chain op.
+// It returns the op after the method.
+func (ris *InstructionScanner) compileMethod(methodTable map[string]RawMethod, prev *op) (MemoizedMethod, *op, error) {
+	methodIdentifier := prev.next
+	if methodIdentifier == nil || methodIdentifier.opCode != opCodeString {
+		return nil, nil, ris.syntaxError(prev.pos+1, ErrMissingMethod)
+	}
+	t, ok := methodTable[methodIdentifier.value.(string)]
+	if !ok {
+		return nil, nil, ris.syntaxError(methodIdentifier.pos,
+			errors.Wrapf(ErrNoMatchingTransformer, "transformer for %s not found", methodIdentifier.value))
+	}
+	var args []Arg
+	var next = methodIdentifier.next
+	if next != nil && next.opCode == opCodeParams {
+		for _, arg := range next.children {
+			switch arg.opCode {
+			case opCodeNil, opCodeString, opCodeFloat, opCodeBool, opCodeInt:
+				args = append(args, Arg{OpCode: arg.opCode, Value: arg.value})
+			default:
+				return nil, nil, ris.syntaxError(arg.pos,
+					errors.Wrapf(ErrInvalidArgument, "%v is not a valid opcode for an argument", arg.opCode.String()))
 			}
 		}
-		return false, nil
-	}, nil
+		next = next.next
+	}
+	memoedFunction, err := t(args...)
+	if err != nil {
+		return nil, nil, ris.syntaxError(methodIdentifier.pos, errors.Wrap(err, "could not compile method"))
+	}
+	return memoedFunction, next, nil
+}
+
+// operator adds an operator op, failing if a negation is waiting for the group it applies to.
+func (ris *InstructionScanner) operator(pos int, code opCode) {
+	if ris.nextInverse() {
+		ris.fail(pos, errors.Wrap(ErrInvalidOpChain, "missing group after negation"))
+	}
+	ris.setOp(&op{
+		opCode: code,
+		pos:    pos,
+	})
 }
 
-// Scan parses the constructor string and turns it into an opcode chain
-func (ris *InstructionScanner) Scan() {
+// Scan parses the constructor string and turns it into an opcode chain.
+// Any syntax error encountered is returned and retained for GetEvaluator.
+func (ris *InstructionScanner) Scan() error {
 	for {
+		pos := ris.scanner.pos
 		c, stop := ris.nextChar()
 		if stop {
 			switch c {
 			case 0:
-				return
+				if ris.err != nil {
+					return ris.err
+				}
+				return nil
 			// Done parsing
 			case '(':
+				if !ris.methodSide {
+					ris.setOp(&op{
+						opCode:  opCodeOpen,
+						inverse: ris.nextInverse(),
+						pos:     pos,
+					})
+					continue
+				}
 				ris.setOp(&op{
-					opCode:  opCodeParams,
-					inverse: ris.nextInverse(),
-					children: mapFilter(
-						setDecoder{
-							scanner:      ris.scanner,
-							activeBuffer: &bytes.Buffer{},
-						}.decode(),
-						func(v string) (*op, bool) {
-							o := ris.genericTokenToOpCode(v)
-							return &o, true
-						}),
+					opCode:   opCodeParams,
+					inverse:  ris.nextInverse(),
+					children: ris.decodeSet(')'),
+					pos:      pos,
 				})
 			case '[':
 				ris.setOp(&op{
-					opCode:  opCodeSet,
-					inverse: ris.nextInverse(),
-					children: mapFilter(
-						setDecoder{
-							scanner:      ris.scanner,
-							activeBuffer: &bytes.Buffer{},
-						}.decode(),
-						func(v string) (*op, bool) {
-							o := ris.genericTokenToOpCode(v)
-							return &o, true
-						}),
+					opCode:   opCodeSet,
+					inverse:  ris.nextInverse(),
+					children: ris.decodeSet(']'),
+					pos:      pos,
 				})
 			case '"':
 				o := ris.genericTokenToOpCode(stringDecoder{
 					scanner:      ris.scanner,
 					activeBuffer: &bytes.Buffer{},
-				}.decode())
+				}.decode(), pos)
 				ris.setOp(&o)
-			case '~':
-				ris.inverseNextOp = true
+			case '~', '!':
+				ris.inverseNextOp = !ris.inverseNextOp
 			case '=':
-				ris.setOp(&op{
-					opCode: opCodeRun,
-				})
+				ris.operator(pos, opCodeRun)
+				ris.methodSide = true
 			case '|':
-				ris.setOp(&op{
-					opCode: opCodeChain,
-				})
+				if ris.methodSide {
+					// A chain pipes into the next method, unless it is followed by another rule
+					rule := ris.ruleFollows()
+					ris.setOp(&op{
+						opCode: opCodeChain,
+						value:  rule,
+						pos:    pos,
+					})
+					ris.methodSide = !rule
+					continue
+				}
+				ris.operator(pos, opCodeOr)
+			case ')':
+				if ris.methodSide {
+					ris.fail(pos, errors.Wrapf(ErrUnexpectedCharacter, "%q", c))
+					continue
+				}
+				ris.operator(pos, opCodeClose)
+			case '&':
+				if ris.methodSide {
+					ris.fail(pos, errors.Wrapf(ErrUnexpectedCharacter, "%q", c))
+					continue
+				}
+				ris.operator(pos, opCodeAnd)
 			default:
-				o := ris.genericTokenToOpCode(tokenDecoder{
-					scanner:      ris.scanner,
-					activeBuffer: &bytes.Buffer{},
-				}.decode())
-				ris.setOp(&o)
+				ris.fail(pos, errors.Wrapf(ErrUnexpectedCharacter, "%q", c))
 			}
 		} else {
 			// unread the byte
 			ris.scanner.pos--
-			o := ris.genericTokenToOpCode(tokenDecoder{
+			value := tokenDecoder{
 				scanner:      ris.scanner,
 				activeBuffer: &bytes.Buffer{},
-			}.decode())
+			}.decode()
+			// Whitespace around tokens is insignificant, e.g. "(support & !eu) | auditor"
+			trimmed := strings.TrimLeft(value, " \t")
+			pos += len(value) - len(trimmed)
+			if trimmed = strings.TrimRight(trimmed, " \t"); len(trimmed) == 0 {
+				continue
+			}
+			o := ris.genericTokenToOpCode(trimmed, pos)
 			ris.setOp(&o)
 		}
 	}
@@ -320,6 +486,14 @@ func NewInstructionScanner(tag string) *InstructionScanner {
 		scanner: &scanner{
 			instruction: []byte(tag),
 		},
-		inverseNextOp: false,
+		inverseNextOp:  false,
+		normalizeGroup: NormalizeGroup,
 	}
 }
+
+// NormalizeGroups sets how group identifiers are normalized, the default is NormalizeGroup.
+// Target groups must be normalized the same way before they are matched against the compiled rules.
+func (ris *InstructionScanner) NormalizeGroups(normalize GroupNormalizer) *InstructionScanner {
+	ris.normalizeGroup = normalize
+	return ris
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
@@ -64,5 +65,60 @@ func TestInstructionScanner_Scan(t *testing.T) {
 		require.Equal(t, opCodeString, scanner.firstOp.next.next.next.next.next.next.next.opCode)
 		require.Equal(t, scanner.currentOp, scanner.firstOp.next.next.next.next.next.next.next)
 	})
+	t.Run("piped methods", func(t *testing.T) {
+		scanner := NewInstructionScanner("all=remove(4)|star(2)")
+		require.NoError(t, scanner.Scan())
+		chain, found := scanner.firstOp.Next(opCodeChain)
+		require.True(t, found)
+		require.Equal(t, "star", chain.next.value)
+		require.Equal(t, opCodeParams, chain.next.next.opCode)
+		require.Equal(t, 2, chain.next.next.children[0].value)
+	})
+
+}
 
+func TestInstructionScanner_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		instruction string
+		pos         int
+		err         error
+	}{
+		{name: "unknown method", instruction: "all=zreo", pos: 4, err: ErrNoMatchingTransformer},
+		{name: "missing method", instruction: "all=", pos: 4, err: ErrMissingMethod},
+		{name: "missing run", instruction: "[admin]", pos: 7, err: ErrInvalidOpChain},
+		{name: "unterminated set", instruction: "[admin,csr=zero", pos: 10, err: ErrUnexpectedCharacter},
+		{name: "unclosed set", instruction: "[admin", pos: 0, err: ErrUnterminated},
+		{name: "mismatched closer", instruction: "[admin)=zero", pos: 6, err: ErrUnexpectedCharacter},
+		{name: "unclosed params", instruction: "all=star(4", pos: 8, err: ErrUnterminated},
+		{name: "unclosed string", instruction: `all=redact("*)`, pos: 11, err: ErrUnterminated},
+		{name: "stray closer", instruction: "all]=zero", pos: 3, err: ErrUnexpectedCharacter},
+		{name: "numeric group", instruction: "[admin,4]=zero", pos: 7, err: ErrInvalidGroup},
+		{name: "missing group", instruction: "=zero", pos: 0, err: ErrInvalidOpChain},
+		{name: "missing operand", instruction: "admin&=zero", pos: 6, err: ErrInvalidOpChain},
+		{name: "dangling negation", instruction: "admin!=zero", pos: 6, err: ErrInvalidOpChain},
+		{name: "unclosed group", instruction: "(admin|csr=zero", pos: 0, err: ErrUnterminated},
+		{name: "unopened group", instruction: "admin)=zero", pos: 5, err: ErrUnexpectedCharacter},
+		{name: "missing operator", instruction: "(admin)(csr)=zero", pos: 7, err: ErrInvalidOpChain},
+		{name: "operator after method", instruction: "all=star(4)&zero", pos: 11, err: ErrUnexpectedCharacter},
+		{name: "missing chained method", instruction: "all=star(4)|", pos: 12, err: ErrMissingMethod},
+		{name: "unknown chained method", instruction: "all=star(4)|zreo", pos: 12, err: ErrNoMatchingTransformer},
+		{name: "method as group after chain", instruction: "all=star(4)|zero|admin=zero", pos: 12, err: ErrAmbiguousRule},
+		{name: "negated method after chain", instruction: "all=star(4)|csr|~[admin,Star]=zero", pos: 24, err: ErrAmbiguousRule},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner := NewInstructionScanner(tt.instruction)
+			scanner.Scan()
+			_, err := scanner.GetEvaluator(map[string]RawMethod{
+				"zero": MethodZero,
+				"star": MethodStar,
+			})
+			var syntaxErr *SyntaxError
+			require.True(t, errors.As(err, &syntaxErr), "%v", err)
+			require.Equal(t, tt.pos, syntaxErr.Pos)
+			require.Equal(t, tt.instruction, syntaxErr.Instruction)
+			require.True(t, errors.Is(err, tt.err), "%v", err)
+		})
+	}
 }
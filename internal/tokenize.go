@@ -0,0 +1,36 @@
+package internal
+
+import "github.com/pkg/errors"
+
+var ErrTokenVaultRequired = errors.New("tokenize requires a token vault")
+
+// TokenVault issues opaque tokens for values and resolves them back, implementations must be safe for concurrent use.
+type TokenVault interface {
+	// Tokenize returns the token for a value, the same value always gets the same token.
+	Tokenize(value string) (string, error)
+	// Detokenize returns the value a token was issued for.
+	Detokenize(token string) (string, error)
+}
+
+// NewMethodTokenize creates the tokenize method bound to a vault.
+// The method takes no arguments and replaces a string with the token the vault issues for it.
+// Example: jane@example.com with tokenize would result in tok_2f1c0a8e93b14d7a6c55e0f4b1a9d283
+func NewMethodTokenize(vault TokenVault) RawMethod {
+	return func(arguments ...Arg) (MemoizedMethod, error) {
+		if vault == nil {
+			return nil, ErrTokenVaultRequired
+		}
+		return func(value any) error {
+			vOf, err := StringValueOf(value)
+			if err != nil {
+				return errors.Wrap(err, "in redaction method tokenize")
+			}
+			token, err := vault.Tokenize(vOf.String())
+			if err != nil {
+				return errors.Wrap(err, "in redaction method tokenize")
+			}
+			vOf.SetString(token)
+			return nil
+		}, nil
+	}
+}
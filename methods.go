@@ -0,0 +1,129 @@
+package redaction
+
+import (
+	"github.com/pkg/errors"
+	"github.com/weisbartb/redact/internal"
+	"reflect"
+	"sync"
+)
+
+// Arg is a single argument passed to a method from a tag, e.g. the 4 in star(4).
+// It provides typed accessors (Int, String, Float, Bool) that coerce the parsed value.
+type Arg = internal.Arg
+
+// Redaction is the compiled form of a Method that is applied to a field.
+// The value is either a settable reflect.Value or a pointer to the field, use ValueOf or StringValueOf to resolve it.
+type Redaction = internal.MemoizedMethod
+
+// Method compiles the arguments supplied in a tag into a Redaction.
+// Methods are compiled once per field and cached for the lifetime of the process,
+// the returned Redaction can be called concurrently and must not hold any mutable state.
+type Method = internal.RawMethod
+
+var ErrMethodExists = errors.New("method is already registered")
+var ErrReservedMethodName = errors.New("method name is reserved")
+var ErrInvalidMethodName = errors.New("invalid method name")
+var ErrNilMethod = errors.New("method can not be nil")
+
+// ErrHashKeyRequired is returned when a tag uses hash on a redactor without WithHashKey or WithHashSalt.
+var ErrHashKeyRequired = internal.ErrHashKeyRequired
+
+// ErrValueCanOnlyBeString is returned by StringValueOf for values that are not strings.
+// A Redaction that returns it for a slice, array or map is applied to each of its elements instead.
+var ErrValueCanOnlyBeString = internal.ErrValueCanOnlyBeString
+
+// ErrValueMustBeReference is returned by ValueOf and StringValueOf for values that can not be set.
+var ErrValueMustBeReference = internal.ErrValueMustBeReference
+
+var builtinMethods = map[string]internal.RawMethod{
+	"zero":   internal.MethodZero,
+	"remove": internal.MethodRemove,
+	"star":   internal.MethodStar,
+	"redact": internal.MethodRedact,
+	"email":  internal.MethodEmail,
+	// hash, tokenize and fpe are replaced with methods bound to the configuration of each redactor
+	"hash":     internal.NewMethodHash(nil, nil),
+	"tokenize": internal.NewMethodTokenize(nil),
+	"fpe":      internal.NewMethodFPE(nil),
+}
+
+// methodTable is a concurrency safe registry of methods that can be referenced from a tag.
+type methodTable struct {
+	mu      sync.RWMutex
+	methods map[string]internal.RawMethod
+}
+
+func newMethodTable() *methodTable {
+	var mt = &methodTable{
+		methods: make(map[string]internal.RawMethod, len(builtinMethods)),
+	}
+	for name, method := range builtinMethods {
+		mt.methods[name] = method
+	}
+	return mt
+}
+
+func (mt *methodTable) register(name string, method Method) error {
+	if err := validMethodName(name); err != nil {
+		return err
+	}
+	if method == nil {
+		return errors.Wrapf(ErrNilMethod, "registering %s", name)
+	}
+	if _, ok := builtinMethods[name]; ok {
+		return errors.Wrapf(ErrReservedMethodName, "%s is a built in method", name)
+	}
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	if _, ok := mt.methods[name]; ok {
+		return errors.Wrapf(ErrMethodExists, "%s", name)
+	}
+	mt.methods[name] = method
+	return nil
+}
+
+// compile builds the rules of a tag while holding a read lock on the table.
+func (mt *methodTable) compile(ris *internal.InstructionScanner) (internal.Rules, error) {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	return ris.GetRules(mt.methods)
+}
+
+// validMethodName ensures a name can be parsed back out of a tag as a method identifier.
+func validMethodName(name string) error {
+	if len(name) == 0 {
+		return errors.Wrap(ErrInvalidMethodName, "name can not be empty")
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return errors.Wrapf(ErrInvalidMethodName, "%q contains an illegal character at %d", name, i)
+		}
+	}
+	return nil
+}
+
+// RegisterMethod makes a custom method available to the tags of the default redactor under the given name.
+// Names must start with a letter or underscore and only contain letters, digits and underscores.
+// Built in methods can not be replaced and a name can only be registered once.
+// This is safe to call concurrently, but should be done during init as tags are compiled (and cached) on first use.
+func RegisterMethod(name string, method Method) error {
+	return defaultRedactor.RegisterMethod(name, method)
+}
+
+// RegisterMethod makes a custom method available to the tags of this redactor, see the package level RegisterMethod.
+func (r *Redactor) RegisterMethod(name string, method Method) error {
+	return r.methods.register(name, method)
+}
+
+// ValueOf resolves the value handed to a Redaction into a settable reflect.Value.
+func ValueOf(value any) (reflect.Value, error) {
+	return internal.ValueOf(value)
+}
+
+// StringValueOf resolves the value handed to a Redaction into a settable reflect.Value of a string kind.
+func StringValueOf(value any) (reflect.Value, error) {
+	return internal.StringValueOf(value)
+}
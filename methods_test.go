@@ -0,0 +1,158 @@
+package redaction
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// methodUpper upper cases a string, optionally only the first n characters.
+func methodUpper(arguments ...Arg) (Redaction, error) {
+	var n = -1
+	if len(arguments) > 0 {
+		n = arguments[0].Int()
+	}
+	return func(value any) error {
+		vOf, err := StringValueOf(value)
+		if err != nil {
+			return err
+		}
+		str := vOf.String()
+		if n < 0 || n > len(str) {
+			vOf.SetString(strings.ToUpper(str))
+		} else {
+			vOf.SetString(strings.ToUpper(str[:n]) + str[n:])
+		}
+		return nil
+	}, nil
+}
+
+type customMethodRecord struct {
+	Policy  string `redact:"all=test_upper"`
+	Account string `redact:"~admin=test_upper(2)"`
+}
+
+func TestRegisterMethod(t *testing.T) {
+	t.Run("custom method", func(t *testing.T) {
+		require.NoError(t, RegisterMethod("test_upper", methodUpper))
+		clean, err := RedactRecord(customMethodRecord{
+			Policy:  "pol-123",
+			Account: "acct",
+		})
+		require.NoError(t, err)
+		require.Equal(t, "POL-123", clean.Policy)
+		require.Equal(t, "ACct", clean.Account)
+		clean, err = RedactRecord(customMethodRecord{Account: "acct"}, "admin")
+		require.NoError(t, err)
+		require.Equal(t, "acct", clean.Account)
+	})
+	t.Run("duplicate", func(t *testing.T) {
+		require.NoError(t, RegisterMethod("test_duplicate", methodUpper))
+		err := RegisterMethod("test_duplicate", methodUpper)
+		require.True(t, errors.Is(err, ErrMethodExists))
+	})
+	t.Run("reserved", func(t *testing.T) {
+		for name := range builtinMethods {
+			err := RegisterMethod(name, methodUpper)
+			require.True(t, errors.Is(err, ErrReservedMethodName), name)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, name := range []string{"", "1up", "up(1)", "a=b", "a|b", `"a"`, "~a", "a b"} {
+			err := RegisterMethod(name, methodUpper)
+			require.True(t, errors.Is(err, ErrInvalidMethodName), name)
+		}
+		require.True(t, errors.Is(RegisterMethod("test_nil", nil), ErrNilMethod))
+	})
+	t.Run("concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		var errs = make([]error, 32)
+		for i := 0; i < len(errs); i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				// Every name is registered twice, exactly one of each pair should win.
+				errs[i] = RegisterMethod(fmt.Sprintf("test_concurrent_%d", i/2), methodUpper)
+			}(i)
+		}
+		wg.Wait()
+		var failures int
+		for _, err := range errs {
+			if err != nil {
+				require.True(t, errors.Is(err, ErrMethodExists))
+				failures++
+			}
+		}
+		require.Equal(t, len(errs)/2, failures)
+	})
+}
+
+type statelessRecord struct {
+	Phone   string `redact:"all=star(-4)"`
+	Account string `redact:"all=remove(-4)"`
+	Card    string `redact:"all=remove(-8)|star(-4)"`
+	Name    string `redact:"all=star(1)"`
+	Code    string `redact:"all=redact(\"#\",\"-\")"`
+	Email   string `redact:"all=hash(4)"`
+	Number  string `redact:"all=fpe"`
+}
+
+// TestMethodsAreStateless redacts the same type repeatedly, as compiled methods are cached for the life of a redactor
+// any state they kept between calls would change what later callers see.
+func TestMethodsAreStateless(t *testing.T) {
+	r := New(WithHashKey([]byte("key")), WithFPEKey([]byte("0123456789abcdef")))
+	var record = statelessRecord{
+		Phone:   "555-555-1234",
+		Account: "acct-9876",
+		Card:    "4111-1111-2222-3333",
+		Name:    "Jane",
+		Code:    "ab-cd",
+		Email:   "jane@example.com",
+		Number:  "123456789",
+	}
+	want, err := RedactWith(r, record)
+	require.NoError(t, err)
+	require.Equal(t, "********1234", want.Phone)
+	require.Equal(t, "9876", want.Account)
+	require.Equal(t, "****3333", want.Card)
+	require.Equal(t, "J***", want.Name)
+	require.Equal(t, "##-##", want.Code)
+	for i := 0; i < 100; i++ {
+		clean, err := RedactWith(r, record)
+		require.NoError(t, err)
+		require.Equal(t, want, clean)
+	}
+	var wg sync.WaitGroup
+	var results = make([]statelessRecord, 64)
+	var errs = make([]error, len(results))
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = RedactWith(r, record)
+		}(i)
+	}
+	wg.Wait()
+	for i := range results {
+		require.NoError(t, errs[i])
+		require.Equal(t, want, results[i])
+	}
+}
+
+type contactRecord struct {
+	Email  string `redact:"~support=email"`
+	Backup string `redact:"all=email(2,\"domain\")"`
+}
+
+func TestEmail(t *testing.T) {
+	var record = contactRecord{Email: "jane@example.com", Backup: "jane.doe@example.org"}
+	clean, err := RedactRecord(record, "marketing")
+	require.NoError(t, err)
+	require.Equal(t, contactRecord{Email: "j***@e******.com", Backup: "ja******@example.org"}, clean)
+	clean, err = RedactRecord(record, "support")
+	require.NoError(t, err)
+	require.Equal(t, record.Email, clean.Email)
+}
@@ -0,0 +1,73 @@
+package redaction
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type nilRecord struct {
+	Ptr    *string           `redact:"~admin=star(1)"`
+	PtrPtr **string          `redact:"~admin=star(1)"`
+	Any    any               `redact:"~admin=zero"`
+	PtrAny *any              `redact:"~admin=star(1)"`
+	AnyPtr any               `redact:"~admin=star(1)"`
+	Slice  []string          `redact:"all=zero"`
+	Map    map[string]string `redact:"key:all=zero"`
+	Record *userRecord
+	Zero   *int `redact:"all=zero"`
+}
+
+func TestRedactNil(t *testing.T) {
+	var nilPtr *string
+	var nilAny any
+	rec := nilRecord{
+		PtrPtr: &nilPtr,
+		PtrAny: &nilAny,
+		AnyPtr: nilPtr,
+	}
+	t.Run("preserve", func(t *testing.T) {
+		for _, groups := range [][]string{nil, {"admin"}} {
+			clean, err := RedactRecord(rec, groups...)
+			require.NoError(t, err)
+			require.Nil(t, clean.Ptr)
+			require.NotNil(t, clean.PtrPtr)
+			require.Nil(t, *clean.PtrPtr)
+			require.Nil(t, clean.Any)
+			require.NotNil(t, clean.PtrAny)
+			require.Nil(t, *clean.PtrAny)
+			require.IsType(t, (*string)(nil), clean.AnyPtr)
+			require.Nil(t, clean.AnyPtr.(*string))
+			require.Nil(t, clean.Slice)
+			require.Nil(t, clean.Map)
+			require.Nil(t, clean.Record)
+			require.Nil(t, clean.Zero)
+		}
+	})
+	t.Run("nil pointer to every depth", func(t *testing.T) {
+		clean, err := RedactRecord(nilRecord{})
+		require.NoError(t, err)
+		require.Equal(t, nilRecord{}, clean)
+		ptrClean, err := RedactRecord[*nilRecord](nil)
+		require.NoError(t, err)
+		require.Nil(t, ptrClean)
+		anyClean, err := RedactRecord[any](nil)
+		require.NoError(t, err)
+		require.Nil(t, anyClean)
+	})
+	t.Run("replace", func(t *testing.T) {
+		r := New(WithNilPolicy(NilReplace))
+		clean, err := RedactWith(r, rec)
+		require.NoError(t, err)
+		require.Equal(t, "", *clean.Ptr)
+		require.Equal(t, "", **clean.PtrPtr)
+		require.Nil(t, clean.Any)
+		require.Nil(t, *clean.PtrAny)
+		require.Equal(t, "", *(clean.AnyPtr.(*string)))
+		// Zero clears the pointer itself, so a nil pointer stays nil.
+		require.Nil(t, clean.Zero)
+		require.Nil(t, clean.Record)
+		// The original pointers must not be written through.
+		require.Nil(t, nilPtr)
+		require.Nil(t, nilAny)
+	})
+}
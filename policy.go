@@ -0,0 +1,155 @@
+package redaction
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/weisbartb/redact/internal"
+	"reflect"
+	"sort"
+)
+
+var ErrKeyRulesRequireMap = errors.New("key rules can only be applied to maps")
+var ErrKeyCollision = errors.New("redacted map keys collide")
+
+// policy is a compiled tag, split into the rules applied to values and the rules applied to map keys.
+type policy struct {
+	value internal.Rules
+	key   internal.Rules
+	// text is the tag the policy was compiled from, references redacted with the same tag are only redacted once
+	text string
+}
+
+// compile compiles a tag into a policy.
+// A tag is made of clauses separated by ';', clauses qualified with key: are applied to map keys,
+// and unqualified (or value: qualified) clauses are applied to values.
+// If tOf is provided, key clauses are rejected for types that can not hold a map.
+func (r *Redactor) compile(tag string, tOf reflect.Type) (policy, error) {
+	clauses, err := internal.SplitClauses(tag)
+	if err != nil {
+		return policy{}, err
+	}
+	var pol = policy{text: tag}
+	for _, clause := range clauses {
+		rules, err := r.methods.compile(r.newScanner(clause.Instruction))
+		if err != nil {
+			var syntaxErr *SyntaxError
+			if errors.As(err, &syntaxErr) {
+				return policy{}, &SyntaxError{Instruction: tag, Pos: clause.Offset + syntaxErr.Pos, Err: syntaxErr.Err}
+			}
+			return policy{}, err
+		}
+		if err := r.checkRules(tag, clause, rules); err != nil {
+			return policy{}, err
+		}
+		if clause.Target == internal.TargetKey {
+			if tOf != nil && !mayContainMap(tOf) {
+				return policy{}, &SyntaxError{Instruction: tag, Pos: clause.Offset, Err: ErrKeyRulesRequireMap}
+			}
+			pol.key = append(pol.key, rules...)
+		} else {
+			pol.value = append(pol.value, rules...)
+		}
+	}
+	return pol, nil
+}
+
+// newScanner creates a scanner for an instruction that normalizes groups the same way the redactor does.
+func (r *Redactor) newScanner(instruction string) *internal.InstructionScanner {
+	return internal.NewInstructionScanner(instruction).NormalizeGroups(r.normalizeGroup)
+}
+
+// evaluate applies the rules selected for the caller to a value, according to the precedence of the redactor.
+// It reports if any rule was applied, callers exempt from every rule are left as is.
+func (p *pass) evaluate(rules internal.Rules, value reflect.Value) (bool, error) {
+	switch p.precedence {
+	case MostSpecific:
+		if idx, _ := rules.MatchMostSpecific(p.groups); idx != internal.Exempt {
+			return true, rules.Apply(idx, value)
+		}
+	case LeastRevealing:
+		var applied bool
+		var selected = make([]bool, len(rules))
+		for _, groups := range p.groupSets {
+			if idx, _ := rules.Match(groups); idx != internal.Exempt {
+				selected[idx] = true
+			}
+		}
+		for idx, ok := range selected {
+			if !ok {
+				continue
+			}
+			applied = true
+			if err := rules.Apply(idx, value); err != nil {
+				return true, err
+			}
+		}
+		return applied, nil
+	default:
+		if idx, _ := rules.Match(p.groups); idx != internal.Exempt {
+			return true, rules.Apply(idx, value)
+		}
+	}
+	return false, nil
+}
+
+// mayContainMap reports if a type is, or can hold, a map without passing through a struct.
+func mayContainMap(tOf reflect.Type) bool {
+	var seen = map[reflect.Type]bool{}
+	for !seen[tOf] {
+		seen[tOf] = true
+		switch tOf.Kind() {
+		case reflect.Map, reflect.Interface:
+			return true
+		case reflect.Pointer, reflect.Slice, reflect.Array:
+			tOf = tOf.Elem()
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// KeyCollision controls what happens when two map keys are identical after redaction.
+type KeyCollision int
+
+const (
+	// KeyCollisionError fails the field with ErrKeyCollision.
+	KeyCollisionError KeyCollision = iota
+	// KeyCollisionSuffix appends #2, #3, etc. to colliding string keys, in the order of the original keys.
+	// Colliding keys that are not strings fail the field with ErrKeyCollision.
+	KeyCollisionSuffix
+)
+
+// redactKey applies the key rules to a map key, resolving any collision with the keys already in out.
+func (p *pass) redactKey(pol policy, out, key reflect.Value) (reflect.Value, error) {
+	// Keys are tracked apart from the values the tag is applied to, references used as keys are redacted by the key rules.
+	redacted, err := p.apply(policy{value: pol.key, text: "key:" + pol.text}, key)
+	if err != nil {
+		return key, err
+	}
+	if !out.MapIndex(redacted).IsValid() {
+		return redacted, nil
+	}
+	if p.keyCollision == KeyCollisionSuffix && redacted.Kind() == reflect.String {
+		var candidate = reflect.New(redacted.Type()).Elem()
+		for i := 2; ; i++ {
+			candidate.SetString(fmt.Sprintf("%s#%d", redacted.String(), i))
+			if !out.MapIndex(candidate).IsValid() {
+				return candidate, nil
+			}
+		}
+	}
+	return key, errors.Wrapf(ErrKeyCollision, "%v", redacted.Interface())
+}
+
+// sortedKeys returns the keys of a map in a deterministic order, so collisions are always resolved the same way.
+func sortedKeys(vOf reflect.Value) []reflect.Value {
+	keys := vOf.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Kind() == reflect.String {
+			return keys[i].String() < keys[j].String()
+		}
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+	return keys
+}
@@ -0,0 +1,92 @@
+package redaction
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"slices"
+	"testing"
+)
+
+type precedenceRecord struct {
+	Inverse string `redact:"~admin=zero"`
+	All     string `redact:"all=zero;admin=star(2)"`
+	Mixed   string `redact:"[~admin,csr]=remove(3)"`
+	Multi   string `redact:"csr=star(1);~[admin,auditor]=zero"`
+	Both    string `redact:"csr=star(1);auditor=remove(3)"`
+}
+
+func TestPrecedence(t *testing.T) {
+	const raw = "abcdef"
+	var newRecord = func() precedenceRecord {
+		return precedenceRecord{Inverse: raw, All: raw, Mixed: raw, Multi: raw, Both: raw}
+	}
+	// Each case lists the expected record for FirstMatch, MostSpecific and LeastRevealing.
+	tests := []struct {
+		groups []string
+		want   [3]precedenceRecord
+	}{
+		{
+			groups: nil,
+			want: [3]precedenceRecord{
+				{Inverse: "", All: "", Mixed: "abc", Multi: "", Both: raw},
+				{Inverse: "", All: "", Mixed: "abc", Multi: "", Both: raw},
+				{Inverse: "", All: "", Mixed: "abc", Multi: "", Both: raw},
+			},
+		},
+		{
+			groups: []string{"csr"},
+			want: [3]precedenceRecord{
+				{Inverse: "", All: "", Mixed: "abc", Multi: "a*****", Both: "a*****"},
+				{Inverse: "", All: "", Mixed: "abc", Multi: "a*****", Both: "a*****"},
+				{Inverse: "", All: "", Mixed: "abc", Multi: "a*****", Both: "a*****"},
+			},
+		},
+		{
+			groups: []string{"admin"},
+			want: [3]precedenceRecord{
+				{Inverse: raw, All: "", Mixed: raw, Multi: raw, Both: raw},
+				{Inverse: raw, All: "ab****", Mixed: raw, Multi: raw, Both: raw},
+				{Inverse: raw, All: "", Mixed: raw, Multi: raw, Both: raw},
+			},
+		},
+		{
+			groups: []string{"csr", "admin"},
+			want: [3]precedenceRecord{
+				{Inverse: raw, All: "", Mixed: "abc", Multi: "a*****", Both: "a*****"},
+				{Inverse: raw, All: "ab****", Mixed: "abc", Multi: "a*****", Both: "a*****"},
+				{Inverse: "", All: "", Mixed: "abc", Multi: "a*****", Both: "a*****"},
+			},
+		},
+		{
+			groups: []string{"auditor", "csr"},
+			want: [3]precedenceRecord{
+				{Inverse: "", All: "", Mixed: "abc", Multi: "a*****", Both: "a*****"},
+				{Inverse: "", All: "", Mixed: "abc", Multi: "a*****", Both: "a*****"},
+				{Inverse: "", All: "", Mixed: "abc", Multi: "a*****", Both: "a**"},
+			},
+		},
+	}
+	for i, precedence := range []Precedence{FirstMatch, MostSpecific, LeastRevealing} {
+		r := New(WithPrecedence(precedence))
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%v %v", precedence, tt.groups), func(t *testing.T) {
+				clean, err := RedactWith(r, newRecord(), tt.groups...)
+				require.NoError(t, err)
+				require.Equal(t, tt.want[i], clean)
+				// The order of the groups never matters.
+				reversed := slices.Clone(tt.groups)
+				slices.Reverse(reversed)
+				clean, err = RedactWith(r, newRecord(), reversed...)
+				require.NoError(t, err)
+				require.Equal(t, tt.want[i], clean)
+			})
+		}
+	}
+	t.Run("hierarchy", func(t *testing.T) {
+		r := New(WithPrecedence(LeastRevealing))
+		require.NoError(t, r.Implies("superadmin", "admin"))
+		clean, err := RedactWith(r, newRecord(), "superadmin")
+		require.NoError(t, err)
+		require.Equal(t, raw, clean.Inverse)
+	})
+}
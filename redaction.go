@@ -1,23 +1,29 @@
 package redaction
 
 import (
+	"context"
+	"fmt"
 	"github.com/pkg/errors"
 	"github.com/weisbartb/rcache"
-	"github.com/weisbartb/redact/internal"
 	"reflect"
 )
 
 var ErrMustBeStruct = errors.New("must be struct or map/slice of structs")
+var ErrUnexportedEmbed = errors.New("embedded pointer to an unexported type can not be redacted")
 
-var Methods = map[string]internal.RawMethod{
-	"zero":   internal.MethodZero,
-	"remove": internal.MethodRemove,
-	"star":   internal.MethodStar,
-	"redact": internal.MethodRedact,
-}
+// ErrFieldNotZeroable is returned with FailClosed when a field that failed can not be zeroed,
+// the zero value of the record is returned instead as it would still hold raw data.
+var ErrFieldNotZeroable = errors.New("failed field can not be zeroed")
 
 type redactionInstruction struct {
-	eval internal.Evaluator
+	// redactor supplies the tag namespace and method table the instruction is compiled with
+	redactor *Redactor
+	policy
+	tag string
+	// records is set when the field may hold records that are redacted by their own tags
+	records bool
+	// err is set when the tag could not be compiled
+	err error
 }
 
 func (r redactionInstruction) FieldName(tag string) string {
@@ -26,7 +32,7 @@ func (r redactionInstruction) FieldName(tag string) string {
 }
 
 func (r redactionInstruction) TagNamespace() string {
-	return "redact"
+	return r.redactor.tagKey
 }
 
 func (r redactionInstruction) Skip(tag string) bool {
@@ -38,14 +44,53 @@ func (r redactionInstruction) Skip(tag string) bool {
 }
 
 func (r redactionInstruction) GetMetadata(fieldType reflect.Type, tag string) rcache.InstructionSet {
-	var resp redactionInstruction
-	ris := internal.NewInstructionScanner(tag)
-	ris.Scan()
-	resp.eval, _ = ris.GetEvaluator(Methods)
+	var resp = redactionInstruction{redactor: r.redactor, tag: tag}
+	resp.policy, resp.err = r.redactor.compile(tag, fieldType)
+	resp.records = r.redactor.mayRedact(fieldType, map[reflect.Type]bool{})
 	return resp
 }
 
-var instructions = rcache.NewCache(redactionInstruction{})
+// Validate compiles every redaction tag reachable from T and reports any that are invalid.
+// The returned error is a FieldErrors listing every broken field, it is intended to be called from tests or at startup.
+func Validate[T any]() error {
+	return ValidateWith[T](defaultRedactor)
+}
+
+// ValidateWith validates T the same way Validate does, using the tag key and methods of the given Redactor.
+func ValidateWith[T any](r *Redactor) error {
+	var errs FieldErrors
+	r.validateType(reflect.TypeOf((*T)(nil)).Elem(), map[reflect.Type]bool{}, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (r *Redactor) validateType(tOf reflect.Type, seen map[reflect.Type]bool, errs *FieldErrors) {
+	for isContainer(tOf) && !seen[tOf] {
+		seen[tOf] = true
+		tOf = tOf.Elem()
+	}
+	if tOf.Kind() != reflect.Struct || seen[tOf] {
+		return
+	}
+	seen[tOf] = true
+	for _, field := range r.instructions.GetTypeDataFor(tOf).Fields() {
+		id := field.InstructionData()
+		if id.err != nil {
+			// Strict groups already fail the tag on its first unknown group.
+			*errs = append(*errs, newFieldError(tOf, field.Idx, id.tag, id.err))
+		} else {
+			for _, err := range r.unknownGroups(id.tag) {
+				*errs = append(*errs, newFieldError(tOf, field.Idx, id.tag, err))
+			}
+		}
+		r.validateType(tOf.Field(field.Idx).Type, seen, errs)
+	}
+	for _, idx := range r.nestedFields(tOf) {
+		r.validateType(tOf.Field(idx).Type, seen, errs)
+	}
+}
 
 // RedactRecord redacts a given record of T and a list of groups the current context belongs to.
 // It will then recursively redact all data that matches the conditions.
@@ -54,153 +99,442 @@ var instructions = rcache.NewCache(redactionInstruction{})
 // This is an intentional decision to not accidentally wipe out non-exported values from the original value.
 // Reflection can't interact with those values, and they may be needed by some call later on (such as open handlers).
 // The response for this is only intended to be used for output encoding.
+// Tags that fail to compile, or methods that fail to apply, are returned as a *FieldError (FieldErrors if several failed).
+// The record returned alongside the error depends on the ErrorPolicy, by default it is the zero value of T.
 func RedactRecord[T any](record T, groups ...string) (T, error) {
+	return RedactWith(defaultRedactor, record, groups...)
+}
+
+// RedactWith redacts a record the same way RedactRecord does, using the configuration of the given Redactor.
+func RedactWith[T any](r *Redactor, record T, groups ...string) (T, error) {
+	return redactWith(context.Background(), r, record, groups)
+}
+
+func redactWith[T any](ctx context.Context, r *Redactor, record T, groups []string) (T, error) {
+	var zero T
+	if len(groups) == 0 {
+		groups = []string{r.defaultGroup}
+	}
 	vOf := reflect.ValueOf(record)
-	out, err := redactRecord(vOf, groups...)
-	if err != nil {
-		return record, err
+	if !vOf.IsValid() {
+		// A nil interface has nothing to redact
+		return zero, nil
 	}
-	return out.Interface().(T), nil
+	if !mayContainRecords(vOf.Type()) {
+		return zero, ErrMustBeStruct
+	}
+	if err := r.checkGroups(groups); err != nil {
+		return zero, err
+	}
+	p := r.newPass(ctx, groups)
+	out, err := p.redactRecord(vOf)
+	if err != nil && (r.errorPolicy == FailZeroRecord || !isFieldError(err)) {
+		return zero, err
+	}
+	return out.Interface().(T), err
 }
-func redactRecord(vOf reflect.Value, groups ...string) (reflect.Value, error) {
-	// Resolve any pointer or interface wrappings to get the underlying type
-	tOf := reflect.Indirect(vOf).Type()
-	// Get the cached instruction recordset
-	var cachedRecord = instructions.GetTypeDataFor(tOf)
-	switch tOf.Kind() {
+
+// RedactInPlace redacts the record a pointer refers to without making a copy of it,
+// every pointer, slice and map reachable from the record is modified directly.
+// This is intended for callers that own the data and discard it after encoding, such as large exports.
+// Please note:
+// Unexported fields can not be modified and are left as is, values only reachable through them are not redacted.
+// References shared with other records are redacted for those records as well.
+// When the ErrorPolicy stops redaction the record is set to its zero value, as it may only be partially redacted.
+func RedactInPlace[T any](record *T, groups ...string) error {
+	return RedactInPlaceWith(defaultRedactor, record, groups...)
+}
+
+// RedactInPlaceWith redacts a record the same way RedactInPlace does, using the configuration of the given Redactor.
+func RedactInPlaceWith[T any](r *Redactor, record *T, groups ...string) error {
+	return redactInPlaceWith(context.Background(), r, record, groups)
+}
+
+func redactInPlaceWith[T any](ctx context.Context, r *Redactor, record *T, groups []string) error {
+	if record == nil {
+		return nil
+	}
+	if len(groups) == 0 {
+		groups = []string{r.defaultGroup}
+	}
+	vOf := reflect.ValueOf(record)
+	if !mayContainRecords(vOf.Type()) {
+		return ErrMustBeStruct
+	}
+	if err := r.checkGroups(groups); err != nil {
+		vOf.Elem().SetZero()
+		return err
+	}
+	p := r.newPass(ctx, groups)
+	p.inPlace = true
+	_, err := p.redactRecord(vOf)
+	if err != nil && (r.errorPolicy == FailZeroRecord || !isFieldError(err)) {
+		vOf.Elem().SetZero()
+	}
+	return err
+}
+
+// pass carries the state of a single redaction call through every level of recursion.
+type pass struct {
+	*Redactor
+	// ctx is checked for cancellation as the record is traversed
+	ctx context.Context
+	// steps counts the values traversed so ctx is only checked periodically
+	steps int
+	// groups the caller belongs to, including the groups they imply
+	groups []string
+	// groupSets holds each group the caller belongs to along with the groups it implies, it is only set for LeastRevealing
+	groupSets [][]string
+	// visited maps the references that have already been redacted to their redacted copy
+	visited map[visitKey]reflect.Value
+	// depth is the current level of recursion
+	depth int
+	// inPlace writes redacted values back into the references of the original record instead of copies
+	inPlace bool
+}
+
+// visitKey identifies a reference by its address and type, slices also include their length.
+type visitKey struct {
+	ptr uintptr
+	tOf reflect.Type
+	len int
+	// policy is the tag the reference was redacted with, it is empty for references redacted as records
+	policy string
+}
+
+func (r *Redactor) newPass(ctx context.Context, groups []string) *pass {
+	var p = &pass{
+		Redactor: r,
+		ctx:      ctx,
+		groups:   r.roles.expand(groups),
+		visited:  map[visitKey]reflect.Value{},
+	}
+	if r.precedence == LeastRevealing {
+		for _, group := range groups {
+			p.groupSets = append(p.groupSets, r.roles.expand([]string{group}))
+		}
+	}
+	return p
+}
+
+// descend enters a new level of recursion, failing if the maximum depth is exceeded or the context is done.
+func (p *pass) descend(tOf reflect.Type) error {
+	p.steps++
+	if p.steps%cancelCheckInterval == 0 {
+		if err := p.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	p.depth++
+	if p.maxDepth > 0 && p.depth > p.maxDepth {
+		p.depth--
+		return &DepthError{Limit: p.maxDepth, Type: tOf}
+	}
+	return nil
+}
+
+func (p *pass) ascend() {
+	p.depth--
+}
+
+// redactRecord returns a redacted copy of vOf, values that can not contain records are returned as is.
+// Pointers, maps and slices that have already been copied are reused, this preserves shared references and
+// ensures cyclic graphs terminate.
+func (p *pass) redactRecord(vOf reflect.Value) (reflect.Value, error) {
+	switch vOf.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+		if vOf.IsNil() {
+			return vOf, nil
+		}
+	}
+	if err := p.descend(vOf.Type()); err != nil {
+		return vOf, err
+	}
+	defer p.ascend()
+	// errs collects the field failures that the error policy allows redaction to continue past
+	var errs FieldErrors
+	switch vOf.Kind() {
+	case reflect.Pointer:
+		key := visitKey{ptr: vOf.Pointer(), tOf: vOf.Type()}
+		if out, ok := p.visited[key]; ok {
+			return out, nil
+		}
+		var out = vOf
+		if !p.inPlace {
+			out = reflect.New(vOf.Type().Elem())
+		}
+		p.visited[key] = out
+		if vOf.Elem().Kind() == reflect.Struct {
+			if !p.inPlace {
+				out.Elem().Set(vOf.Elem())
+			}
+			if err := p.redactStruct(out.Elem()); !p.collect(&errs, err) {
+				return vOf, err
+			}
+			return out, errs.orNil()
+		}
+		item, err := p.redactRecord(vOf.Elem())
+		if !p.collect(&errs, err) {
+			return vOf, err
+		}
+		out.Elem().Set(item)
+		return out, errs.orNil()
 	case reflect.Interface:
 		// Interfaces need to be unwrapped to their underlying types
 		var out = reflect.New(vOf.Type()).Elem()
-		var returnPtr bool
-		if vOf.Kind() == reflect.Pointer {
-			returnPtr = true
-			vOf = vOf.Elem()
-		}
-		item, err := redactRecord(vOf.Elem(), groups...)
-		if err != nil {
+		item, err := p.redactRecord(vOf.Elem())
+		if !p.collect(&errs, err) {
 			return vOf, err
 		}
 		out.Set(item)
-		if returnPtr {
-			return out.Addr(), nil
-		}
-		return out, nil
+		return out, errs.orNil()
 	case reflect.Slice:
-		var out reflect.Value
-		var addr bool
-		var addressableSlice reflect.Value
-		if vOf.Kind() == reflect.Pointer {
-			out = reflect.New(reflect.SliceOf(tOf.Elem())).Elem()
-			addr = true
-			addressableSlice = out
-			vOf = vOf.Elem()
-		} else {
-			out = reflect.MakeSlice(vOf.Type(), 0, 0)
+		key := visitKey{ptr: vOf.Pointer(), tOf: vOf.Type(), len: vOf.Len()}
+		if out, ok := p.visited[key]; ok {
+			return out, nil
+		}
+		var out = vOf
+		if !p.inPlace {
+			out = reflect.MakeSlice(vOf.Type(), vOf.Len(), vOf.Len())
 		}
+		p.visited[key] = out
 		for i := 0; i < vOf.Len(); i++ {
-			item, err := redactRecord(vOf.Index(i), groups...)
-			if err != nil {
+			item, err := p.redactRecord(vOf.Index(i))
+			if !p.collect(&errs, err) {
 				return vOf, err
 			}
-			out = reflect.Append(out, item)
-		}
-		if addr {
-			addressableSlice.Set(out)
-			return addressableSlice.Addr(), nil
+			out.Index(i).Set(item)
 		}
-		return out, nil
+		return out, errs.orNil()
 	case reflect.Array:
-		var out reflect.Value
-		var addr bool
-		out = reflect.New(reflect.ArrayOf(vOf.Len(), tOf.Elem())).Elem()
-		if vOf.Kind() == reflect.Pointer {
-			addr = true
-			vOf = vOf.Elem()
-		}
+		var out = p.target(vOf)
 		for i := 0; i < vOf.Len(); i++ {
-			item, err := redactRecord(vOf.Index(i), groups...)
-			if err != nil {
+			item, err := p.redactRecord(vOf.Index(i))
+			if !p.collect(&errs, err) {
 				return vOf, err
 			}
 			out.Index(i).Set(item)
 		}
-		if addr {
-			return out.Addr(), nil
-		}
-		return out, nil
+		return out, errs.orNil()
 	case reflect.Map:
-		var out reflect.Value
-		var addr bool
-		if vOf.Kind() == reflect.Pointer {
-			out = reflect.New(reflect.MapOf(tOf.Key(), tOf.Elem())).Elem()
-			addr = true
-			vOf = vOf.Elem()
-			out.Set(reflect.MakeMap(vOf.Type()))
-		} else {
-			out = reflect.MakeMap(vOf.Type())
+		key := visitKey{ptr: vOf.Pointer(), tOf: vOf.Type()}
+		if out, ok := p.visited[key]; ok {
+			return out, nil
 		}
-
-		for _, key := range vOf.MapKeys() {
-			item, err := redactRecord(vOf.MapIndex(key), groups...)
-			if err != nil {
+		var out = vOf
+		if !p.inPlace {
+			out = reflect.MakeMapWithSize(vOf.Type(), vOf.Len())
+		}
+		p.visited[key] = out
+		iter := vOf.MapRange()
+		for iter.Next() {
+			item, err := p.redactRecord(iter.Value())
+			if !p.collect(&errs, err) {
 				return vOf, err
 			}
-			out.SetMapIndex(key, item)
+			out.SetMapIndex(iter.Key(), item)
 		}
-		if addr {
-			return out.Addr(), nil
-		}
-		return out, nil
+		return out, errs.orNil()
 	case reflect.Struct:
-		// pass down
-	default:
-		return vOf, ErrMustBeStruct
-	}
-	var addr = vOf.Kind() == reflect.Pointer
-	var out = reflect.New(tOf).Elem()
-	if addr {
-		out.Set(vOf.Elem())
-	} else {
-		out.Set(vOf)
-	}
-	for _, field := range cachedRecord.Fields() {
-		fieldV := out.Field(field.Idx)
-		var typeStack []reflect.Type
-		for fieldV.Kind() == reflect.Ptr || fieldV.Kind() == reflect.Interface {
-			ogVal := fieldV.Elem()
-			typeStack = append(typeStack, fieldV.Type())
-			fieldV = reflect.New(fieldV.Elem().Type()).Elem()
-			fieldV.Set(ogVal)
-		}
-		if len(field.Fields()) > 0 {
-			item, err := redactRecord(fieldV)
-			if err != nil {
-				return vOf, err
+		var out = p.target(vOf)
+		if err := p.redactStruct(out); !p.collect(&errs, err) {
+			return vOf, err
+		}
+		return out, errs.orNil()
+	}
+	return vOf, nil
+}
+
+// target returns the value a redacted copy of an array or struct is built in.
+// In place passes redact settable values directly, anything else is copied first.
+func (p *pass) target(vOf reflect.Value) reflect.Value {
+	if p.inPlace && vOf.CanSet() {
+		return vOf
+	}
+	var out = reflect.New(vOf.Type()).Elem()
+	out.Set(vOf)
+	return out
+}
+
+// redactStruct redacts the fields of an addressable struct in place.
+func (p *pass) redactStruct(out reflect.Value) error {
+	var tOf = out.Type()
+	var errs FieldErrors
+	for _, field := range p.instructions.GetTypeDataFor(tOf).Fields() {
+		id := field.InstructionData()
+		if id.err != nil {
+			if !p.failField(&errs, out, field.Idx, newFieldError(tOf, field.Idx, id.tag, id.err)) {
+				return p.halt(errs)
 			}
-			fieldV.Set(item)
 			continue
 		}
-		id := field.InstructionData()
-		if id.eval != nil {
-			if _, err := id.eval(fieldV, groups...); err != nil {
-				return vOf, err
+		var pol = id.policy
+		if id.records {
+			// Records are redacted by their own tags first, the rules of the field are then applied to the result.
+			if err := p.redactField(out, field.Idx, id.tag); !p.collect(&errs, err) {
+				return err
 			}
-			if len(typeStack) > 0 {
-				for i := len(typeStack) - 1; i >= 0; i-- {
-					tmp := reflect.New(typeStack[i]).Elem()
-					if typeStack[i].Kind() == reflect.Pointer {
-						tmp.Set(fieldV.Addr())
-					} else {
-						tmp.Set(fieldV)
-					}
-					fieldV = tmp
-				}
-				out.Field(field.Idx).Set(fieldV)
+		}
+		if pol.value == nil && pol.key == nil {
+			continue
+		}
+		item, err := p.apply(pol, out.Field(field.Idx))
+		if err != nil {
+			if !p.failField(&errs, out, field.Idx, newFieldError(tOf, field.Idx, id.tag, err)) {
+				return p.halt(errs)
 			}
+			continue
+		}
+		out.Field(field.Idx).Set(item)
+	}
+	for _, idx := range p.nestedFields(tOf) {
+		if err := p.redactField(out, idx, ""); !p.collect(&errs, err) {
+			return err
+		}
+	}
+	return errs.orNil()
+}
+
+// redactField redacts the records held by a field of an addressable struct.
+// Struct values (including embedded structs) are redacted in place, anything else is replaced with a redacted copy.
+func (p *pass) redactField(out reflect.Value, idx int, tag string) error {
+	fieldV := out.Field(idx)
+	if fieldV.Kind() == reflect.Struct {
+		return p.redactStruct(fieldV)
+	}
+	if !fieldV.CanSet() {
+		// Embedded pointers to unexported types can't be replaced, redacting them would mutate the original.
+		var fe = newFieldError(out.Type(), idx, tag, ErrUnexportedEmbed)
+		if p.errorPolicy == FailClosed {
+			return p.halt(FieldErrors{fe})
+		}
+		return fe
+	}
+	item, err := p.redactRecord(fieldV)
+	if err != nil && (p.errorPolicy == FailZeroRecord || !isFieldError(err)) {
+		return err
+	}
+	fieldV.Set(item)
+	return err
+}
+
+// nestedFields returns the indexes of the untagged fields of a struct that may hold records with redaction tags.
+// In deep copy mode this includes every field that holds a reference.
+func (r *Redactor) nestedFields(tOf reflect.Type) []int {
+	if cached, ok := r.nested.Load(tOf); ok {
+		return cached.([]int)
+	}
+	var idxs []int
+	for i := 0; i < tOf.NumField(); i++ {
+		f := tOf.Field(i)
+		if (!f.IsExported() && !f.Anonymous) || len(f.Tag.Get(r.tagKey)) > 0 {
+			continue
+		}
+		if r.mayRedact(f.Type, map[reflect.Type]bool{}) {
+			idxs = append(idxs, i)
+		}
+	}
+	r.nested.Store(tOf, idxs)
+	return idxs
+}
+
+// mayRedact reports if a type can hold a value with redaction tags, or in deep copy mode, any reference.
+// Types that are already being inspected are skipped, their result is decided by the outer inspection.
+func (r *Redactor) mayRedact(tOf reflect.Type, inspecting map[reflect.Type]bool) bool {
+	for isContainer(tOf) {
+		if r.deepCopy && tOf.Kind() != reflect.Array {
+			// References always need to be copied
+			return true
 		}
+		if inspecting[tOf] {
+			return false
+		}
+		inspecting[tOf] = true
+		tOf = tOf.Elem()
+	}
+	switch tOf.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Struct:
+		if inspecting[tOf] {
+			return false
+		}
+		inspecting[tOf] = true
+	default:
+		return false
+	}
+	for i := 0; i < tOf.NumField(); i++ {
+		f := tOf.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		if len(f.Tag.Get(r.tagKey)) > 0 || r.mayRedact(f.Type, inspecting) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayContainRecords reports if a type is, or can hold, a struct.
+func mayContainRecords(tOf reflect.Type) bool {
+	var seen = map[reflect.Type]bool{}
+	for isContainer(tOf) && !seen[tOf] {
+		seen[tOf] = true
+		tOf = tOf.Elem()
+	}
+	return tOf.Kind() == reflect.Struct || tOf.Kind() == reflect.Interface
+}
+
+// isContainer reports if a type wraps an element type that needs to be traversed.
+func isContainer(tOf reflect.Type) bool {
+	switch tOf.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		return true
+	}
+	return false
+}
+
+// collect merges a nested error into errs, it reports false if the error policy requires redaction to stop.
+func (r *Redactor) collect(errs *FieldErrors, err error) bool {
+	if err == nil {
+		return true
 	}
-	if addr {
-		return out.Addr(), nil
+	if r.errorPolicy == FailZeroRecord {
+		return false
 	}
-	return out, nil
+	switch fe := err.(type) {
+	case *FieldError:
+		*errs = append(*errs, fe)
+	case FieldErrors:
+		*errs = append(*errs, fe...)
+	default:
+		return false
+	}
+	return true
+}
+
+// halt returns the error that stops redaction after errs.
+// Under FailClosed it is escalated past the field errors the policy accepts, so the partial copy is never returned.
+func (r *Redactor) halt(errs FieldErrors) error {
+	if r.errorPolicy == FailClosed {
+		return fmt.Errorf("%w: %w", ErrFieldNotZeroable, errs.orNil())
+	}
+	return errs.orNil()
+}
+
+// failField records a field that could not be redacted and applies the error policy to it.
+// It reports false if the error policy requires redaction to stop.
+func (r *Redactor) failField(errs *FieldErrors, out reflect.Value, idx int, fe *FieldError) bool {
+	*errs = append(*errs, fe)
+	switch r.errorPolicy {
+	case FailClosed:
+		if !out.Field(idx).CanSet() {
+			return false
+		}
+		out.Field(idx).Set(reflect.Zero(out.Field(idx).Type()))
+		return true
+	case FailOpen:
+		return true
+	}
+	return false
 }
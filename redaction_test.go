@@ -1,7 +1,11 @@
 package redaction
 
 import (
+	"fmt"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/redact/internal"
+	"reflect"
 	"testing"
 )
 
@@ -248,3 +252,349 @@ func TestRedactRecords(t *testing.T) {
 	})
 
 }
+
+type brokenRecord struct {
+	Name     string `redact:"all=zreo"`
+	Set      string `redact:"[admin,csr=zero"`
+	Valid    string `redact:"all=zero"`
+	Untagged string
+}
+
+type brokenParentRecord struct {
+	Child  *brokenRecord `redact:"all=zero"`
+	Method string        `redact:"all="`
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		require.NoError(t, Validate[userRecord]())
+		require.NoError(t, Validate[[]*userRecord]())
+	})
+	t.Run("invalid", func(t *testing.T) {
+		err := Validate[brokenRecord]()
+		require.Error(t, err)
+		var errs FieldErrors
+		require.True(t, errors.As(err, &errs))
+		require.Len(t, errs, 2)
+		require.Equal(t, reflect.TypeOf(brokenRecord{}), errs[0].Type)
+		require.Equal(t, "Name", errs[0].Field)
+		require.Equal(t, "all=zreo", errs[0].Tag)
+		require.Equal(t, 4, errs[0].Pos)
+		require.True(t, errors.Is(errs[0], internal.ErrNoMatchingTransformer))
+		require.Equal(t, "Set", errs[1].Field)
+		require.Equal(t, 10, errs[1].Pos)
+		require.True(t, errors.Is(errs[1], internal.ErrUnexpectedCharacter))
+	})
+	t.Run("nested", func(t *testing.T) {
+		var errs FieldErrors
+		require.True(t, errors.As(Validate[map[string]brokenParentRecord](), &errs))
+		require.Len(t, errs, 3)
+		require.Equal(t, "Name", errs[0].Field)
+		require.Equal(t, "Set", errs[1].Field)
+		require.Equal(t, reflect.TypeOf(brokenParentRecord{}), errs[2].Type)
+		require.Equal(t, "Method", errs[2].Field)
+		require.Equal(t, 4, errs[2].Pos)
+		require.True(t, errors.Is(errs[2], internal.ErrMissingMethod))
+	})
+}
+
+func TestRedactRecordTagErrors(t *testing.T) {
+	_, err := RedactRecord(brokenRecord{Name: "name"})
+	var fieldErr *FieldError
+	require.True(t, errors.As(err, &fieldErr))
+	require.Equal(t, "Name", fieldErr.Field)
+	require.Equal(t, 4, fieldErr.Pos)
+	require.Contains(t, err.Error(), `"all=zreo"`)
+	require.Contains(t, err.Error(), "brokenRecord.Name")
+}
+
+type partiallyBrokenRecord struct {
+	Secret   string `redact:"all=zreo"`
+	Count    int    `redact:"all=star(1)"`
+	Password string `redact:"all=zero"`
+	Name     string
+}
+
+type embeddedSecret struct {
+	X string `redact:"all=zero"`
+}
+
+type brokenEmbedRecord struct {
+	embeddedSecret `redact:"all=bogus"`
+	Secret         string `redact:"all=zero"`
+}
+
+type unexportedEmbedRecord struct {
+	*embeddedSecret
+	Secret string `redact:"all=zero"`
+}
+
+func TestErrorPolicy(t *testing.T) {
+	rec := partiallyBrokenRecord{
+		Secret:   "secret",
+		Count:    42,
+		Password: "password",
+		Name:     "name",
+	}
+	t.Run("fail zero record", func(t *testing.T) {
+		clean, err := RedactRecord(rec)
+		require.Error(t, err)
+		require.Equal(t, partiallyBrokenRecord{}, clean)
+		ptrClean, err := RedactRecord(&rec)
+		require.Error(t, err)
+		require.Nil(t, ptrClean)
+	})
+	t.Run("fail closed", func(t *testing.T) {
+		clean, err := RedactWith(New(WithErrorPolicy(FailClosed)), rec)
+		var errs FieldErrors
+		require.True(t, errors.As(err, &errs))
+		require.Len(t, errs, 2)
+		require.Equal(t, "Secret", errs[0].Field)
+		require.Equal(t, 4, errs[0].Pos)
+		require.Equal(t, "Count", errs[1].Field)
+		require.Equal(t, -1, errs[1].Pos)
+		require.True(t, errors.Is(err, ErrValueCanOnlyBeString))
+		require.Equal(t, partiallyBrokenRecord{Name: "name"}, clean)
+	})
+	t.Run("fail closed unzeroable", func(t *testing.T) {
+		r := New(WithErrorPolicy(FailClosed))
+		broken, err := RedactWith(r, brokenEmbedRecord{embeddedSecret: embeddedSecret{X: "raw"}, Secret: "raw"})
+		require.True(t, errors.Is(err, ErrFieldNotZeroable))
+		require.True(t, errors.Is(err, internal.ErrNoMatchingTransformer))
+		require.Equal(t, brokenEmbedRecord{}, broken)
+		embedded := &embeddedSecret{X: "raw"}
+		unexported, err := RedactWith(r, unexportedEmbedRecord{embeddedSecret: embedded, Secret: "raw"})
+		require.True(t, errors.Is(err, ErrFieldNotZeroable))
+		require.True(t, errors.Is(err, ErrUnexportedEmbed))
+		require.Equal(t, unexportedEmbedRecord{}, unexported)
+		inPlace := unexportedEmbedRecord{embeddedSecret: embedded, Secret: "raw"}
+		require.True(t, errors.Is(RedactInPlaceWith(r, &inPlace), ErrFieldNotZeroable))
+		require.Equal(t, unexportedEmbedRecord{}, inPlace)
+		require.Equal(t, "raw", embedded.X)
+	})
+	t.Run("fail open", func(t *testing.T) {
+		clean, err := RedactWith(New(WithErrorPolicy(FailOpen)), []partiallyBrokenRecord{rec, rec})
+		var errs FieldErrors
+		require.True(t, errors.As(err, &errs))
+		require.Len(t, errs, 4)
+		require.Len(t, clean, 2)
+		require.Equal(t, partiallyBrokenRecord{Secret: "secret", Count: 42, Name: "name"}, clean[1])
+	})
+	t.Run("original is never returned", func(t *testing.T) {
+		clean, err := RedactWith(New(WithErrorPolicy(FailOpen)), &rec)
+		require.Error(t, err)
+		require.NotSame(t, &rec, clean)
+		require.Equal(t, "password", rec.Password)
+	})
+}
+
+type nestedRecord struct {
+	User     userRecord
+	UserPtr  *userRecord
+	Users    []userRecord
+	UserMap  map[string]*userRecord
+	Any      any
+	Union    unionRecord
+	Stacked  *stackedRecord
+	Nil      *userRecord
+	NilSlice []*userRecord
+}
+
+type ownedRecord struct {
+	Owner   *userRecord           `redact:"~admin=zero"`
+	Team    []userRecord          `redact:"~admin=zero"`
+	Manager userRecord            `redact:"~admin=zero"`
+	ByID    map[string]userRecord `redact:"key:all=star(1);value:~admin=zero"`
+	Lead    *userRecord           `redact:"all=star(1)"`
+}
+
+type taggedAnyRecord struct {
+	Any   any            `redact:"admin=zero"`
+	Items []any          `redact:"admin=zero"`
+	M     map[string]any `redact:"admin=zero"`
+}
+
+func newUserRecord() userRecord {
+	return userRecord{
+		Username:             "Test",
+		Email:                "test@test.com",
+		Password:             "testp",
+		LastName:             "lname",
+		FirstName:            "fname",
+		InterfaceTest:        "test",
+		PointerTest:          strPointer("testptr"),
+		InterfacePointerTest: strPointer("testptr"),
+		nonExported:          strPointer("tmp"),
+	}
+}
+
+func requireUserRedacted(t *testing.T, admin bool, rec userRecord) {
+	t.Helper()
+	require.Equal(t, "Test", rec.Username)
+	require.Equal(t, "", rec.Password)
+	require.Equal(t, "fname", rec.FirstName)
+	if admin {
+		require.Equal(t, "test@test.com", rec.Email)
+		require.Equal(t, "lname", rec.LastName)
+		require.Equal(t, "testptr", *rec.PointerTest)
+		require.Equal(t, "test", rec.InterfaceTest)
+		require.Equal(t, "testptr", *(rec.InterfacePointerTest.(*string)))
+	} else {
+		require.Equal(t, "test*********", rec.Email)
+		require.Equal(t, "l", rec.LastName)
+		require.Equal(t, "t******", *rec.PointerTest)
+		require.Equal(t, "t", rec.InterfaceTest)
+		require.Equal(t, "t", *(rec.InterfacePointerTest.(*string)))
+	}
+}
+
+func TestRedactNestedRecords(t *testing.T) {
+	newRecord := func() nestedRecord {
+		return nestedRecord{
+			User:    newUserRecord(),
+			UserPtr: &[]userRecord{newUserRecord()}[0],
+			Users:   []userRecord{newUserRecord(), newUserRecord()},
+			UserMap: map[string]*userRecord{"a": &[]userRecord{newUserRecord()}[0]},
+			Any:     newUserRecord(),
+			Union:   unionRecord{newUserRecord()},
+			Stacked: &stackedRecord{
+				userRecord: newUserRecord(),
+				Embedded:   newUserRecord(),
+			},
+			NilSlice: []*userRecord{nil},
+		}
+	}
+	for _, admin := range []bool{false, true} {
+		var groups []string
+		if admin {
+			groups = []string{"admin"}
+		}
+		t.Run(fmt.Sprintf("admin=%v", admin), func(t *testing.T) {
+			rec := newRecord()
+			clean, err := RedactRecord(&rec, groups...)
+			require.NoError(t, err)
+			requireUserRedacted(t, admin, clean.User)
+			requireUserRedacted(t, admin, *clean.UserPtr)
+			requireUserRedacted(t, admin, clean.Users[0])
+			requireUserRedacted(t, admin, clean.Users[1])
+			requireUserRedacted(t, admin, *clean.UserMap["a"])
+			requireUserRedacted(t, admin, clean.Any.(userRecord))
+			requireUserRedacted(t, admin, clean.Union.userRecord)
+			requireUserRedacted(t, admin, clean.Stacked.userRecord)
+			requireUserRedacted(t, admin, clean.Stacked.Embedded)
+			require.Nil(t, clean.Nil)
+			require.Equal(t, []*userRecord{nil}, clean.NilSlice)
+			// The original record must not be touched.
+			require.Equal(t, newRecord(), rec)
+		})
+	}
+	t.Run("value rules", func(t *testing.T) {
+		newOwned := func() ownedRecord {
+			return ownedRecord{
+				Owner:   &[]userRecord{newUserRecord()}[0],
+				Team:    []userRecord{newUserRecord()},
+				Manager: newUserRecord(),
+				ByID:    map[string]userRecord{"12": newUserRecord()},
+			}
+		}
+		clean, err := RedactRecord(newOwned())
+		require.NoError(t, err)
+		// The values of a map with key rules are zeroed one at a time.
+		require.Equal(t, ownedRecord{ByID: map[string]userRecord{"1*": {}}}, clean)
+		clean, err = RedactRecord(newOwned(), "admin")
+		require.NoError(t, err)
+		requireUserRedacted(t, true, *clean.Owner)
+		requireUserRedacted(t, true, clean.Team[0])
+		requireUserRedacted(t, true, clean.Manager)
+		requireUserRedacted(t, true, clean.ByID["1*"])
+		// String methods can't be applied to a record.
+		rec := newOwned()
+		rec.Lead = &[]userRecord{newUserRecord()}[0]
+		_, err = RedactRecord(rec, "admin")
+		var fieldErr *FieldError
+		require.True(t, errors.As(err, &fieldErr))
+		require.Equal(t, "Lead", fieldErr.Field)
+		require.True(t, errors.Is(err, ErrValueCanOnlyBeString))
+	})
+	t.Run("tagged interfaces", func(t *testing.T) {
+		newTagged := func() taggedAnyRecord {
+			return taggedAnyRecord{
+				Any:   newUserRecord(),
+				Items: []any{newUserRecord(), &[]userRecord{newUserRecord()}[0]},
+				M:     map[string]any{"a": newUserRecord()},
+			}
+		}
+		// Records held by tagged interfaces are still redacted by their own tags.
+		clean, err := RedactRecord(newTagged(), "csr")
+		require.NoError(t, err)
+		requireUserRedacted(t, false, clean.Any.(userRecord))
+		requireUserRedacted(t, false, clean.Items[0].(userRecord))
+		requireUserRedacted(t, false, *clean.Items[1].(*userRecord))
+		requireUserRedacted(t, false, clean.M["a"].(userRecord))
+		clean, err = RedactRecord(newTagged(), "admin")
+		require.NoError(t, err)
+		require.Equal(t, taggedAnyRecord{}, clean)
+	})
+	t.Run("embedded", func(t *testing.T) {
+		for _, admin := range []bool{false, true} {
+			var groups []string
+			if admin {
+				groups = []string{"admin"}
+			}
+			union, err := RedactRecord(unionRecord{newUserRecord()}, groups...)
+			require.NoError(t, err)
+			requireUserRedacted(t, admin, union.userRecord)
+			stacked, err := RedactRecord([]stackedRecord{{userRecord: newUserRecord(), Embedded: newUserRecord()}}, groups...)
+			require.NoError(t, err)
+			requireUserRedacted(t, admin, stacked[0].userRecord)
+			requireUserRedacted(t, admin, stacked[0].Embedded)
+		}
+	})
+}
+
+type regionRecord struct {
+	Phone string `redact:"(support & !eu) | auditor=star(4)"`
+	Notes string `redact:"~admin=zero|all=remove(2)"`
+	Audit string `redact:"~admin=zero|(support & !eu)|auditor=star(4)"`
+}
+
+func TestGroupExpressions(t *testing.T) {
+	tests := []struct {
+		groups []string
+		want   regionRecord
+	}{
+		{groups: []string{"support"}, want: regionRecord{Phone: "5551******", Notes: "", Audit: ""}},
+		{groups: []string{"support", "eu"}, want: regionRecord{Phone: "5551234567", Notes: "", Audit: ""}},
+		{groups: []string{"eu", "auditor"}, want: regionRecord{Phone: "5551******", Notes: "", Audit: ""}},
+		// Admins are exempt from ~admin, the rules after it are not checked.
+		{groups: []string{"admin"}, want: regionRecord{Phone: "5551234567", Notes: "notes", Audit: "5551234567"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.groups), func(t *testing.T) {
+			clean, err := RedactRecord(regionRecord{Phone: "5551234567", Notes: "notes", Audit: "5551234567"}, tt.groups...)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, clean)
+		})
+	}
+}
+
+type chainedRecord struct {
+	Card  string `redact:"~admin=remove(6)|star(2)"`
+	Phone string `redact:"csr=remove(3)|star(1)|~admin=zero"`
+	Email string `redact:"all=star(2)|admin|csr=zero"`
+}
+
+func TestMethodChains(t *testing.T) {
+	var newRecord = func() chainedRecord {
+		return chainedRecord{Card: "4111111111111111", Phone: "555-555-1234", Email: "jane@example.com"}
+	}
+	clean, err := RedactRecord(newRecord())
+	require.NoError(t, err)
+	require.Equal(t, chainedRecord{Card: "41****", Phone: "", Email: "ja**************"}, clean)
+	clean, err = RedactRecord(newRecord(), "csr")
+	require.NoError(t, err)
+	require.Equal(t, chainedRecord{Card: "41****", Phone: "5**", Email: "ja**************"}, clean)
+	clean, err = RedactRecord(newRecord(), "admin")
+	require.NoError(t, err)
+	require.Equal(t, chainedRecord{Card: "4111111111111111", Phone: "555-555-1234", Email: "ja**************"}, clean)
+}
@@ -0,0 +1,278 @@
+package redaction
+
+import (
+	"github.com/weisbartb/rcache"
+	"github.com/weisbartb/redact/internal"
+	"sync"
+)
+
+// DefaultTagKey is the struct tag namespace read by redactors that do not set WithTagKey.
+const DefaultTagKey = "redact"
+
+// DefaultMaxDepth is the maximum level of nesting redactors that do not set WithMaxDepth will traverse.
+const DefaultMaxDepth = 10000
+
+// DefaultGroup is the group used by redactors that do not set WithDefaultGroup when no groups are provided.
+const DefaultGroup = "none"
+
+// ErrorPolicy controls what a Redactor returns when a field can not be redacted,
+// either because its tag failed to compile or because a method returned an error.
+type ErrorPolicy int
+
+const (
+	// FailZeroRecord stops at the first failure and returns the zero value of the record along with the error.
+	FailZeroRecord ErrorPolicy = iota
+	// FailClosed zeroes every field that could not be redacted and returns the rest of the redacted copy with the error.
+	// A field that can not be zeroed, such as an unexported one, fails with ErrFieldNotZeroable and the zero record.
+	FailClosed
+	// FailOpen leaves fields that could not be redacted untouched and returns the partially redacted copy with the error.
+	// This can expose raw data and should only be used when the caller discards the record on error.
+	FailOpen
+)
+
+func (p ErrorPolicy) String() string {
+	switch p {
+	case FailZeroRecord:
+		return "fail-zero-record"
+	case FailClosed:
+		return "fail-closed"
+	case FailOpen:
+		return "fail-open"
+	}
+	return "unknown"
+}
+
+// NilPolicy controls how nil pointers in tagged fields are handled.
+type NilPolicy int
+
+const (
+	// NilPreserve leaves nil values as nil, methods are not run against them.
+	NilPreserve NilPolicy = iota
+	// NilReplace replaces nil pointers in fields with value rules with a pointer to the redacted zero value,
+	// so the output does not reveal whether the value was set. Nil interfaces, slices and maps are left as nil.
+	// This applies to every caller, even those the rules exempt. Zero still sets the pointer to nil.
+	NilReplace
+)
+
+// Precedence controls which rules of a tag are applied when a caller belongs to several groups.
+// The result never depends on the order the caller's groups are listed in.
+type Precedence int
+
+const (
+	// FirstMatch applies the first rule satisfied by the caller's groups, in the order the rules are written.
+	// A rule that applies to everyone except one of the caller's groups, such as ~admin, exempts the caller
+	// and no later rule is checked.
+	FirstMatch Precedence = iota
+	// MostSpecific selects a rule the same way FirstMatch does, except rules that name one of the caller's groups are
+	// checked before rules that only apply to the caller through all or a negation.
+	// With all=zero;admin=star(2) an admin sees star(2) rather than zero.
+	MostSpecific
+	// LeastRevealing selects a rule for each of the caller's groups on its own (along with the groups it implies)
+	// as FirstMatch does, and applies every selected rule in the order they are written.
+	// A caller is only exempt from a rule if every one of its groups is, so a csr that is also an admin
+	// is redacted as a csr by ~admin=zero. Expressions that combine several of the caller's groups with & never hold.
+	LeastRevealing
+)
+
+func (p Precedence) String() string {
+	switch p {
+	case FirstMatch:
+		return "first-match"
+	case MostSpecific:
+		return "most-specific"
+	case LeastRevealing:
+		return "least-revealing"
+	}
+	return "unknown"
+}
+
+// Redactor holds the configuration used to redact records.
+// Each redactor has its own method table, tag key and instruction cache,
+// this allows multiple libraries in the same binary to use different policies without interfering with each other.
+type Redactor struct {
+	errorPolicy  ErrorPolicy
+	keyCollision KeyCollision
+	nilPolicy    NilPolicy
+	precedence   Precedence
+	maxDepth     int
+	deepCopy     bool
+	strictGroups bool
+	tagKey       string
+	defaultGroup string
+	// groupResolver resolves the groups of the viewer for the Ctx variants
+	groupResolver GroupResolver
+	methods       *methodTable
+	hashKey       []byte
+	hashSalt      []byte
+	vault         TokenVault
+	// detokenizers are the groups authorized to Detokenize
+	detokenizers []string
+	fpeKey       []byte
+	// decryptors are the groups authorized to DecryptFPE
+	decryptors []string
+	roles      *roleHierarchy
+	groups     *groupRegistry
+	// normalizeGroup is applied to group identifiers in tags and to the groups of callers
+	normalizeGroup internal.GroupNormalizer
+	// knownGroups are declared once the group normalizer is final
+	knownGroups  []string
+	instructions *rcache.Cache[redactionInstruction]
+	// nested caches the untagged fields of a struct type that need to be recursed into
+	nested sync.Map
+	// policies caches the evaluators compiled for RedactValue
+	policies sync.Map
+}
+
+// Option configures a Redactor.
+type Option func(r *Redactor)
+
+// WithErrorPolicy sets how fields that can not be redacted are handled, the default is FailZeroRecord.
+func WithErrorPolicy(policy ErrorPolicy) Option {
+	return func(r *Redactor) {
+		r.errorPolicy = policy
+	}
+}
+
+// WithKeyCollision sets how map keys that are identical after redaction are handled, the default is KeyCollisionError.
+func WithKeyCollision(collision KeyCollision) Option {
+	return func(r *Redactor) {
+		r.keyCollision = collision
+	}
+}
+
+// WithNilPolicy sets how nil pointers in tagged fields are handled, the default is NilPreserve.
+func WithNilPolicy(nilPolicy NilPolicy) Option {
+	return func(r *Redactor) {
+		r.nilPolicy = nilPolicy
+	}
+}
+
+// WithPrecedence sets which rules are applied to callers that belong to several groups, the default is FirstMatch.
+func WithPrecedence(precedence Precedence) Option {
+	return func(r *Redactor) {
+		r.precedence = precedence
+	}
+}
+
+// WithMaxDepth sets the maximum level of nesting that is traversed before failing with a DepthError,
+// the default is DefaultMaxDepth. A limit of 0 or less disables the check.
+func WithMaxDepth(depth int) Option {
+	return func(r *Redactor) {
+		r.maxDepth = depth
+	}
+}
+
+// WithDeepCopy makes every exported pointer, slice and map reachable from a redacted record a fresh allocation,
+// so mutating the copy can never reach the original record (or vice versa). Shared references remain shared in the copy.
+// Unexported fields can not be set through reflection and are still copied shallowly.
+func WithDeepCopy() Option {
+	return func(r *Redactor) {
+		r.deepCopy = true
+	}
+}
+
+// WithTagKey sets the struct tag namespace that is read, for example "mask" would read `mask:"all=zero"`.
+func WithTagKey(key string) Option {
+	return func(r *Redactor) {
+		r.tagKey = key
+	}
+}
+
+// WithDefaultGroup sets the group used when a record is redacted without any groups.
+func WithDefaultGroup(group string) Option {
+	return func(r *Redactor) {
+		r.defaultGroup = group
+	}
+}
+
+// WithHashKey sets the key the hash method uses to compute an HMAC-SHA-256 of values,
+// values hashed with the same key can be joined on but not reversed without it.
+func WithHashKey(key []byte) Option {
+	return func(r *Redactor) {
+		r.hashKey = append([]byte(nil), key...)
+	}
+}
+
+// WithHashSalt sets the salt the hash method prepends to values before hashing them,
+// without a key set by WithHashKey the digest is a salted SHA-256.
+func WithHashSalt(salt []byte) Option {
+	return func(r *Redactor) {
+		r.hashSalt = append([]byte(nil), salt...)
+	}
+}
+
+// WithUnicodeGroupFolding compares groups with Unicode simple case folding, e.g. "ÄDMIN" matches "ädmin".
+// By default groups are lower cased, which does not match letters that only fold together, such as "σ" and "ς".
+// Surrounding whitespace is always ignored.
+func WithUnicodeGroupFolding() Option {
+	return func(r *Redactor) {
+		r.normalizeGroup = internal.FoldGroup
+	}
+}
+
+// WithKnownGroups declares the groups tags are expected to use,
+// Validate reports any tag that names a group that has not been declared.
+func WithKnownGroups(groups ...string) Option {
+	return func(r *Redactor) {
+		r.knownGroups = append(r.knownGroups, groups...)
+	}
+}
+
+// WithStrictGroups rejects groups that have not been declared with WithKnownGroups or DeclareGroups,
+// tags that name one fail to compile and records can not be redacted for one. The default group is always accepted.
+func WithStrictGroups() Option {
+	return func(r *Redactor) {
+		r.strictGroups = true
+	}
+}
+
+// WithGroupResolver sets how RedactRecordCtx and the other Ctx variants derive groups from a context,
+// the default reads the groups stored with WithGroups.
+func WithGroupResolver(resolver GroupResolver) Option {
+	return func(r *Redactor) {
+		r.groupResolver = resolver
+	}
+}
+
+// New creates a new Redactor with the given options.
+// Custom methods are registered on the returned redactor with RegisterMethod.
+func New(opts ...Option) *Redactor {
+	var r = &Redactor{
+		errorPolicy:    FailZeroRecord,
+		tagKey:         DefaultTagKey,
+		defaultGroup:   DefaultGroup,
+		maxDepth:       DefaultMaxDepth,
+		methods:        newMethodTable(),
+		normalizeGroup: internal.NormalizeGroup,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if len(r.tagKey) == 0 {
+		r.tagKey = DefaultTagKey
+	}
+	if r.groupResolver == nil {
+		r.groupResolver = contextGroups
+	}
+	if r.defaultGroup = r.normalizeGroup(r.defaultGroup); len(r.defaultGroup) == 0 {
+		r.defaultGroup = DefaultGroup
+	}
+	r.methods.methods["hash"] = internal.NewMethodHash(r.hashKey, r.hashSalt)
+	r.methods.methods["tokenize"] = internal.NewMethodTokenize(r.vault)
+	r.methods.methods["fpe"] = internal.NewMethodFPE(r.fpeKey)
+	for _, groups := range [][]string{r.detokenizers, r.decryptors} {
+		for i, group := range groups {
+			groups[i] = r.normalizeGroup(group)
+		}
+	}
+	r.roles = newRoleHierarchy(r.normalizeGroup)
+	r.groups = newGroupRegistry(r.normalizeGroup)
+	// Option errors can't be returned, empty groups are ignored rather than rejecting every declared group
+	for _, group := range r.knownGroups {
+		_ = r.groups.declare([]string{group})
+	}
+	r.instructions = rcache.NewCache(redactionInstruction{redactor: r})
+	return r
+}
+
+var defaultRedactor = New()
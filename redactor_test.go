@@ -0,0 +1,70 @@
+package redaction
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/redact/internal"
+	"testing"
+)
+
+type maskedRecord struct {
+	Email    string `mask:"~admin=star(2)" redact:"all=zero"`
+	Password string `mask:"all=zero"`
+	Account  string `mask:"all=test_hide"`
+}
+
+func methodHide(arguments ...Arg) (Redaction, error) {
+	return func(value any) error {
+		vOf, err := StringValueOf(value)
+		if err != nil {
+			return err
+		}
+		vOf.SetString("hidden")
+		return nil
+	}, nil
+}
+
+func TestRedactor(t *testing.T) {
+	rec := maskedRecord{
+		Email:    "test@test.com",
+		Password: "password",
+		Account:  "12345",
+	}
+	t.Run("tag key", func(t *testing.T) {
+		r := New(WithTagKey("mask"))
+		require.NoError(t, r.RegisterMethod("test_hide", methodHide))
+		clean, err := RedactWith(r, rec)
+		require.NoError(t, err)
+		require.Equal(t, "te***********", clean.Email)
+		require.Equal(t, "", clean.Password)
+		require.Equal(t, "hidden", clean.Account)
+		clean, err = RedactWith(r, rec, "admin")
+		require.NoError(t, err)
+		require.Equal(t, "test@test.com", clean.Email)
+		// The default redactor reads its own tag namespace.
+		clean, err = RedactRecord(rec)
+		require.NoError(t, err)
+		require.Equal(t, "", clean.Email)
+		require.Equal(t, "password", clean.Password)
+	})
+	t.Run("isolated method tables", func(t *testing.T) {
+		r := New(WithTagKey("mask"))
+		require.NoError(t, r.RegisterMethod("test_hide", methodHide))
+		other := New(WithTagKey("mask"))
+		require.NoError(t, ValidateWith[maskedRecord](r))
+		err := ValidateWith[maskedRecord](other)
+		require.True(t, errors.Is(err, internal.ErrNoMatchingTransformer))
+		_, err = RedactWith(other, rec)
+		require.Error(t, err)
+	})
+	t.Run("default group", func(t *testing.T) {
+		r := New(WithTagKey("mask"), WithDefaultGroup("Admin"))
+		require.NoError(t, r.RegisterMethod("test_hide", methodHide))
+		clean, err := RedactWith(r, maskedRecord{Email: "test@test.com"})
+		require.NoError(t, err)
+		require.Equal(t, "test@test.com", clean.Email)
+		clean, err = RedactWith(r, maskedRecord{Email: "test@test.com"}, "user")
+		require.NoError(t, err)
+		require.Equal(t, "te***********", clean.Email)
+	})
+}
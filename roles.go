@@ -0,0 +1,81 @@
+package redaction
+
+import (
+	"github.com/pkg/errors"
+	"github.com/weisbartb/redact/internal"
+	"sync"
+)
+
+var ErrInvalidGroupName = errors.New("invalid group name")
+
+// roleHierarchy is a concurrency safe registry of the groups each group implies.
+type roleHierarchy struct {
+	mu        sync.RWMutex
+	implies   map[string][]string
+	normalize internal.GroupNormalizer
+}
+
+func newRoleHierarchy(normalize internal.GroupNormalizer) *roleHierarchy {
+	return &roleHierarchy{implies: map[string][]string{}, normalize: normalize}
+}
+
+func (h *roleHierarchy) add(group string, implied []string) error {
+	group = h.normalize(group)
+	if len(group) == 0 {
+		return errors.Wrap(ErrInvalidGroupName, "group can not be empty")
+	}
+	var normalized = make([]string, 0, len(implied))
+	for _, v := range implied {
+		v = h.normalize(v)
+		if len(v) == 0 {
+			return errors.Wrapf(ErrInvalidGroupName, "%s can not imply an empty group", group)
+		}
+		normalized = append(normalized, v)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.implies[group] = append(h.implies[group], normalized...)
+	return nil
+}
+
+// expand normalizes the groups and returns them followed by every group they imply, transitively and without duplicates.
+func (h *roleHierarchy) expand(groups []string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var expanded = make([]string, 0, len(groups))
+	var seen = make(map[string]bool, len(groups))
+	for _, group := range groups {
+		group = h.normalize(group)
+		if !seen[group] {
+			seen[group] = true
+			expanded = append(expanded, group)
+		}
+	}
+	// expanded doubles as the queue of groups whose implications have not been followed yet
+	for i := 0; i < len(expanded); i++ {
+		for _, implied := range h.implies[expanded[i]] {
+			if !seen[implied] {
+				seen[implied] = true
+				expanded = append(expanded, implied)
+			}
+		}
+	}
+	return expanded
+}
+
+// Implies registers groups that are implied by a group on the default redactor, see (*Redactor).Implies.
+func Implies(group string, implied ...string) error {
+	return defaultRedactor.Implies(group, implied...)
+}
+
+// Implies registers groups that are implied by a group, a caller in the group is treated as a member of each implied
+// group (and the groups they imply) when rules are evaluated. For example after
+//
+//	r.Implies("superadmin", "admin")
+//	r.Implies("admin", "csr")
+//
+// a superadmin caller is exempt from ~[csr]=remove(1). Implications are additive and can not be removed,
+// they are resolved when a record is redacted so they can be registered at any time.
+func (r *Redactor) Implies(group string, implied ...string) error {
+	return r.roles.add(group, implied)
+}
@@ -0,0 +1,55 @@
+package redaction
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type roleRecord struct {
+	Notes   string `redact:"~[csr]=remove(1)"`
+	Salary  string `redact:"~admin=zero"`
+	Secrets string `redact:"~superadmin=star(1)"`
+}
+
+func TestImplies(t *testing.T) {
+	newRecord := func() roleRecord {
+		return roleRecord{Notes: "notes", Salary: "100", Secrets: "secret"}
+	}
+	r := New()
+	require.NoError(t, r.Implies("SuperAdmin", "admin"))
+	require.NoError(t, r.Implies("admin", "csr"))
+	tests := []struct {
+		name   string
+		groups []string
+		want   roleRecord
+	}{
+		{name: "none", want: roleRecord{Notes: "n", Salary: "", Secrets: "s*****"}},
+		{name: "csr", groups: []string{"csr"}, want: roleRecord{Notes: "notes", Salary: "", Secrets: "s*****"}},
+		{name: "admin", groups: []string{"admin"}, want: roleRecord{Notes: "notes", Salary: "100", Secrets: "s*****"}},
+		{name: "superadmin", groups: []string{"superadmin"}, want: newRecord()},
+		{name: "order does not matter", groups: []string{"csr", "admin"}, want: roleRecord{Notes: "notes", Salary: "100", Secrets: "s*****"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clean, err := RedactWith(r, newRecord(), test.groups...)
+			require.NoError(t, err)
+			require.Equal(t, test.want, clean)
+		})
+	}
+	t.Run("independent redactors", func(t *testing.T) {
+		clean, err := RedactRecord(newRecord(), "superadmin")
+		require.NoError(t, err)
+		require.Equal(t, roleRecord{Notes: "n", Salary: "", Secrets: "secret"}, clean)
+	})
+	t.Run("cycles", func(t *testing.T) {
+		r := New()
+		require.NoError(t, r.Implies("a", "b"))
+		require.NoError(t, r.Implies("b", "a", "c"))
+		require.Equal(t, []string{"b", "a", "c"}, r.roles.expand([]string{"B"}))
+	})
+	t.Run("invalid", func(t *testing.T) {
+		require.True(t, errors.Is(r.Implies("", "admin"), ErrInvalidGroupName))
+		require.True(t, errors.Is(r.Implies("admin", ""), ErrInvalidGroupName))
+	})
+}
@@ -0,0 +1,232 @@
+package redaction
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"reflect"
+)
+
+// RedactValue applies an explicit policy to a value that does not carry its own tags, such as a []string of emails
+// or a map[string]string of headers. The policy uses the same syntax as a tag, e.g. "~admin=star(2)".
+// Methods that only accept strings redact collections element-wise, zero clears them, and a redacted copy is returned, the original value is never modified.
+// On error the zero value of T is returned.
+func RedactValue[T any](value T, policy string, groups ...string) (T, error) {
+	return RedactValueWith(defaultRedactor, value, policy, groups...)
+}
+
+// RedactValueWith redacts a value the same way RedactValue does, using the configuration of the given Redactor.
+func RedactValueWith[T any](r *Redactor, value T, policy string, groups ...string) (T, error) {
+	var zero T
+	if len(groups) == 0 {
+		groups = []string{r.defaultGroup}
+	}
+	if err := r.checkGroups(groups); err != nil {
+		return zero, err
+	}
+	pol, err := r.compilePolicy(policy)
+	if err != nil {
+		return zero, err
+	}
+	vOf := reflect.ValueOf(&value).Elem()
+	p := r.newPass(context.Background(), groups)
+	out, err := p.apply(pol, vOf)
+	if err != nil {
+		return zero, err
+	}
+	return out.Interface().(T), nil
+}
+
+// compilePolicy compiles a policy that is not attached to a struct field, the result is cached by the policy text.
+func (r *Redactor) compilePolicy(text string) (policy, error) {
+	if cached, ok := r.policies.Load(text); ok {
+		return cached.(policy), nil
+	}
+	pol, err := r.compile(text, nil)
+	if err != nil {
+		return policy{}, err
+	}
+	r.policies.Store(text, pol)
+	return pol, nil
+}
+
+// apply runs a policy against a value and returns a redacted copy of it.
+// Value rules are applied to pointers, interfaces, slices, arrays and maps as a whole, so zero clears the field.
+// If the selected method only accepts strings, pointers and interfaces are followed to the value they hold and
+// slices, arrays and maps are redacted element-wise. Key rules are applied to the keys of every map that is encountered,
+// the values of a map with key rules are always redacted element-wise.
+// Nil values are returned as is, no method is run against them unless the NilReplace policy is set.
+// In place passes redact the references of vOf directly rather than copying them.
+// A reference shared by several values is only redacted once per policy, its copy is shared the same way.
+func (p *pass) apply(pol policy, vOf reflect.Value) (_ reflect.Value, err error) {
+	if err := p.descend(vOf.Type()); err != nil {
+		return vOf, err
+	}
+	defer p.ascend()
+	key, shared := referenceKey(pol, vOf)
+	if shared {
+		if out, ok := p.visited[key]; ok {
+			return out, nil
+		}
+		defer func() {
+			// A failed reference is redacted again where it is shared, it must not be handed out partially redacted.
+			if err != nil {
+				delete(p.visited, key)
+			}
+		}()
+	}
+	if (isContainer(vOf.Type()) || vOf.Kind() == reflect.Interface) && (vOf.Kind() != reflect.Map || pol.key == nil) {
+		if out, ok, err := p.applyWhole(pol, vOf); ok || err != nil {
+			if shared && err == nil {
+				p.visited[key] = out
+			}
+			return out, err
+		}
+	}
+	var out = reflect.New(vOf.Type()).Elem()
+	switch vOf.Kind() {
+	case reflect.Pointer:
+		if vOf.IsNil() {
+			if p.nilPolicy != NilReplace || pol.value == nil {
+				return vOf, nil
+			}
+			// Replace the nil pointer with the redacted zero value it points to.
+			vOf = reflect.New(vOf.Type().Elem())
+		}
+		if p.inPlace {
+			out.Set(vOf)
+		} else {
+			out.Set(reflect.New(vOf.Type().Elem()))
+		}
+		if shared {
+			p.visited[key] = out
+		}
+		item, err := p.apply(pol, vOf.Elem())
+		if err != nil {
+			return vOf, err
+		}
+		out.Elem().Set(item)
+	case reflect.Interface:
+		if vOf.IsNil() {
+			return vOf, nil
+		}
+		item, err := p.apply(pol, vOf.Elem())
+		if err != nil {
+			return vOf, err
+		}
+		out.Set(item)
+	case reflect.Slice:
+		if vOf.IsNil() {
+			return vOf, nil
+		}
+		if p.inPlace {
+			out.Set(vOf)
+		} else {
+			out.Set(reflect.MakeSlice(vOf.Type(), vOf.Len(), vOf.Len()))
+		}
+		p.visited[key] = out
+		for i := 0; i < vOf.Len(); i++ {
+			item, err := p.apply(pol, vOf.Index(i))
+			if err != nil {
+				return vOf, err
+			}
+			out.Index(i).Set(item)
+		}
+	case reflect.Array:
+		for i := 0; i < vOf.Len(); i++ {
+			item, err := p.apply(pol, vOf.Index(i))
+			if err != nil {
+				return vOf, err
+			}
+			out.Index(i).Set(item)
+		}
+	case reflect.Map:
+		if vOf.IsNil() {
+			return vOf, nil
+		}
+		if pol.key == nil {
+			if p.inPlace {
+				out.Set(vOf)
+			} else {
+				out.Set(reflect.MakeMapWithSize(vOf.Type(), vOf.Len()))
+			}
+			p.visited[key] = out
+			iter := vOf.MapRange()
+			for iter.Next() {
+				item, err := p.apply(pol, iter.Value())
+				if err != nil {
+					return vOf, err
+				}
+				out.SetMapIndex(iter.Key(), item)
+			}
+			break
+		}
+		out.Set(reflect.MakeMapWithSize(vOf.Type(), vOf.Len()))
+		if p.inPlace {
+			p.visited[key] = vOf
+		} else {
+			p.visited[key] = out
+		}
+		for _, mapKey := range sortedKeys(vOf) {
+			item, err := p.apply(pol, vOf.MapIndex(mapKey))
+			if err != nil {
+				return vOf, err
+			}
+			redactedKey, err := p.redactKey(pol, out, mapKey)
+			if err != nil {
+				return vOf, err
+			}
+			out.SetMapIndex(redactedKey, item)
+		}
+		if p.inPlace {
+			// Keys can't be replaced while iterating, the original map is refilled from the redacted copy.
+			vOf.Clear()
+			iter := out.MapRange()
+			for iter.Next() {
+				vOf.SetMapIndex(iter.Key(), iter.Value())
+			}
+			out.Set(vOf)
+		}
+	default:
+		out.Set(vOf)
+		if pol.value == nil {
+			break
+		}
+		if _, err := p.evaluate(pol.value, out); err != nil {
+			return vOf, err
+		}
+	}
+	return out, nil
+}
+
+// applyWhole applies the value rules to a pointer, interface or collection itself rather than the values it holds.
+// It reports false if no rule was applied or the selected method only accepts strings, the caller then descends into vOf.
+func (p *pass) applyWhole(pol policy, vOf reflect.Value) (reflect.Value, bool, error) {
+	if pol.value == nil {
+		return vOf, false, nil
+	}
+	var out = reflect.New(vOf.Type()).Elem()
+	out.Set(vOf)
+	applied, err := p.evaluate(pol.value, out)
+	if errors.Is(err, ErrValueCanOnlyBeString) {
+		return vOf, false, nil
+	}
+	if err != nil {
+		return vOf, false, err
+	}
+	return out, applied, nil
+}
+
+// referenceKey returns the key a non-nil pointer, slice or map is tracked by while a policy is applied to it.
+func referenceKey(pol policy, vOf reflect.Value) (visitKey, bool) {
+	switch vOf.Kind() {
+	case reflect.Pointer, reflect.Map:
+		if !vOf.IsNil() {
+			return visitKey{ptr: vOf.Pointer(), tOf: vOf.Type(), policy: pol.text}, true
+		}
+	case reflect.Slice:
+		if !vOf.IsNil() {
+			return visitKey{ptr: vOf.Pointer(), tOf: vOf.Type(), len: vOf.Len(), policy: pol.text}, true
+		}
+	}
+	return visitKey{}, false
+}
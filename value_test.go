@@ -0,0 +1,184 @@
+package redaction
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/weisbartb/redact/internal"
+	"testing"
+)
+
+type collectionRecord struct {
+	Emails   []string          `redact:"~admin=star(2)"`
+	Headers  map[string]string `redact:"~admin=redact"`
+	Phones   [2]string         `redact:"all=remove(3)"`
+	Pointers []*string         `redact:"all=star(1)"`
+	Nested   [][]string        `redact:"all=zero"`
+	Nil      []string          `redact:"all=zero"`
+}
+
+type zeroedRecord struct {
+	M map[string]string `redact:"all=zero"`
+	S []string          `redact:"all=zero"`
+	P *string           `redact:"all=zero"`
+	A any               `redact:"all=zero"`
+}
+
+func TestZeroCollections(t *testing.T) {
+	newRec := func() zeroedRecord {
+		return zeroedRecord{
+			M: map[string]string{"jane@example.com": "x"},
+			S: []string{"a", "b"},
+			P: strPointer("secret"),
+			A: []string{"a"},
+		}
+	}
+	clean, err := RedactRecord(newRec())
+	require.NoError(t, err)
+	require.Equal(t, zeroedRecord{}, clean)
+	rec := newRec()
+	require.NoError(t, RedactInPlace(&rec))
+	require.Equal(t, zeroedRecord{}, rec)
+}
+
+func TestRedactValue(t *testing.T) {
+	t.Run("slice", func(t *testing.T) {
+		emails := []string{"a@test.com", "b@test.com"}
+		clean, err := RedactValue(emails, "~admin=star(2)")
+		require.NoError(t, err)
+		require.Equal(t, []string{"a@********", "b@********"}, clean)
+		require.Equal(t, []string{"a@test.com", "b@test.com"}, emails)
+		clean, err = RedactValue(emails, "~admin=star(2)", "admin")
+		require.NoError(t, err)
+		require.Equal(t, emails, clean)
+	})
+	t.Run("map", func(t *testing.T) {
+		headers := map[string]string{"Authorization": "Bearer abc"}
+		clean, err := RedactValue(headers, "all=redact")
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"Authorization": "**********"}, clean)
+		require.Equal(t, "Bearer abc", headers["Authorization"])
+	})
+	t.Run("scalar", func(t *testing.T) {
+		clean, err := RedactValue("555-555-1234", "all=remove(-4)")
+		require.NoError(t, err)
+		require.Equal(t, "1234", clean)
+		str := "secret"
+		ptr, err := RedactValue(&str, "all=zero")
+		require.NoError(t, err)
+		require.Nil(t, ptr)
+		require.Equal(t, "secret", str)
+	})
+	t.Run("nil", func(t *testing.T) {
+		clean, err := RedactValue([]string(nil), "all=zero")
+		require.NoError(t, err)
+		require.Nil(t, clean)
+	})
+	t.Run("invalid policy", func(t *testing.T) {
+		clean, err := RedactValue([]string{"secret"}, "all=zreo")
+		require.True(t, errors.Is(err, internal.ErrNoMatchingTransformer))
+		require.Nil(t, clean)
+	})
+	t.Run("method error", func(t *testing.T) {
+		clean, err := RedactValue([]int{1}, "all=star")
+		require.True(t, errors.Is(err, ErrValueCanOnlyBeString))
+		require.Nil(t, clean)
+	})
+}
+
+func TestRedactCollectionFields(t *testing.T) {
+	rec := collectionRecord{
+		Emails:   []string{"a@test.com"},
+		Headers:  map[string]string{"Cookie": "abc"},
+		Phones:   [2]string{"555-555-1234", "555-555-4321"},
+		Pointers: []*string{strPointer("test"), nil},
+		Nested:   [][]string{{"a", "b"}},
+	}
+	clean, err := RedactRecord(rec)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a@********"}, clean.Emails)
+	require.Equal(t, map[string]string{"Cookie": "***"}, clean.Headers)
+	require.Equal(t, [2]string{"555", "555"}, clean.Phones)
+	require.Equal(t, "t***", *clean.Pointers[0])
+	require.Nil(t, clean.Pointers[1])
+	require.Nil(t, clean.Nested)
+	require.Nil(t, clean.Nil)
+	// Element-wise redaction must not write through to the original backing arrays.
+	require.Equal(t, "a@test.com", rec.Emails[0])
+	require.Equal(t, "abc", rec.Headers["Cookie"])
+	require.Equal(t, "test", *rec.Pointers[0])
+	require.Equal(t, "a", rec.Nested[0][0])
+
+	admin, err := RedactRecord(rec, "admin")
+	require.NoError(t, err)
+	require.Equal(t, rec.Emails, admin.Emails)
+	require.Equal(t, rec.Headers, admin.Headers)
+}
+
+type keyedRecord struct {
+	ByEmail   map[string]string      `redact:"key:~admin=star(1);value:all=zero"`
+	Users     map[string]*userRecord `redact:"key:all=redact"`
+	KeysOnly  map[string]int         `redact:"key:all=star(3)"`
+	Collision map[string]string      `redact:"key:all=remove(1)"`
+}
+
+type invalidKeyRecord struct {
+	Name string `redact:"key:all=zero"`
+}
+
+type invalidQualifierRecord struct {
+	Values map[string]string `redact:"keys:all=zero"`
+}
+
+func TestRedactMapKeys(t *testing.T) {
+	rec := keyedRecord{
+		ByEmail:  map[string]string{"jane@test.com": "secret"},
+		Users:    map[string]*userRecord{"123": &[]userRecord{newUserRecord()}[0]},
+		KeysOnly: map[string]int{"jane@test.com": 1, "joe@test.com": 2},
+	}
+	t.Run("keys and values", func(t *testing.T) {
+		clean, err := RedactRecord(rec)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"j************": ""}, clean.ByEmail)
+		require.Len(t, clean.Users, 1)
+		requireUserRedacted(t, false, *clean.Users["***"])
+		require.Equal(t, map[string]int{"jan**********": 1, "joe*********": 2}, clean.KeysOnly)
+		require.Equal(t, "secret", rec.ByEmail["jane@test.com"])
+		clean, err = RedactRecord(rec, "admin")
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"jane@test.com": ""}, clean.ByEmail)
+		requireUserRedacted(t, true, *clean.Users["***"])
+	})
+	t.Run("collision error", func(t *testing.T) {
+		_, err := RedactRecord(keyedRecord{Collision: map[string]string{"ab": "1", "ac": "2"}})
+		require.True(t, errors.Is(err, ErrKeyCollision))
+		var fieldErr *FieldError
+		require.True(t, errors.As(err, &fieldErr))
+		require.Equal(t, "Collision", fieldErr.Field)
+	})
+	t.Run("collision suffix", func(t *testing.T) {
+		r := New(WithKeyCollision(KeyCollisionSuffix))
+		for i := 0; i < 10; i++ {
+			clean, err := RedactWith(r, keyedRecord{Collision: map[string]string{"ab": "1", "ac": "2", "ad": "3"}})
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{"a": "1", "a#2": "2", "a#3": "3"}, clean.Collision)
+		}
+	})
+	t.Run("redact value", func(t *testing.T) {
+		clean, err := RedactValue(map[string]string{"jane@test.com": "x"}, "key:all=remove(4)")
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"jane": "x"}, clean)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		var fieldErr *FieldError
+		require.True(t, errors.As(Validate[invalidKeyRecord](), &fieldErr))
+		require.True(t, errors.Is(fieldErr, ErrKeyRulesRequireMap))
+		require.Equal(t, 4, fieldErr.Pos)
+		require.True(t, errors.As(Validate[invalidQualifierRecord](), &fieldErr))
+		require.True(t, errors.Is(fieldErr, internal.ErrUnknownQualifier))
+		require.Equal(t, 0, fieldErr.Pos)
+		_, err := RedactValue(map[string]string{}, "value:all=zero;key:all=zreo")
+		var syntaxErr *SyntaxError
+		require.True(t, errors.As(err, &syntaxErr))
+		require.Equal(t, 23, syntaxErr.Pos)
+	})
+}
@@ -0,0 +1,191 @@
+package redaction
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/weisbartb/redact/internal"
+	"os"
+	"sync"
+)
+
+// TokenPrefix starts every token issued by the vaults in this package.
+const TokenPrefix = "tok_"
+
+var ErrTokenNotFound = errors.New("token not found")
+
+// ErrTokenVaultRequired is returned when a tag uses tokenize, or Detokenize is called, on a redactor without WithTokenVault.
+var ErrTokenVaultRequired = internal.ErrTokenVaultRequired
+
+// TokenVault stores the values replaced by the tokenize method, so they can be recovered with Detokenize.
+// Tokenize must return the same token for the same value and Detokenize must return ErrTokenNotFound
+// for tokens it did not issue. Implementations must be safe for concurrent use.
+type TokenVault = internal.TokenVault
+
+// WithTokenVault sets the vault the tokenize method stores values in,
+// the authorized groups (and groups that imply them) can recover values with Detokenize.
+func WithTokenVault(vault TokenVault, authorized ...string) Option {
+	return func(r *Redactor) {
+		r.vault = vault
+		r.detokenizers = append(r.detokenizers, authorized...)
+	}
+}
+
+// Detokenize returns the value a token was issued for, if one of the groups is authorized by WithTokenVault.
+func (r *Redactor) Detokenize(token string, groups ...string) (string, error) {
+	if r.vault == nil {
+		return "", ErrTokenVaultRequired
+	}
+	if err := r.authorize(groups, r.detokenizers); err != nil {
+		return "", err
+	}
+	return r.vault.Detokenize(token)
+}
+
+// DetokenizeCtx returns the value a token was issued for the same way Detokenize does, the groups are resolved from ctx.
+func (r *Redactor) DetokenizeCtx(ctx context.Context, token string) (string, error) {
+	groups, err := r.resolveGroups(ctx)
+	if err != nil {
+		return "", err
+	}
+	return r.Detokenize(token, groups...)
+}
+
+// newToken generates a random token, the 128 bits of randomness make collisions negligible.
+func newToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", errors.Wrap(err, "generating token")
+	}
+	return TokenPrefix + hex.EncodeToString(b[:]), nil
+}
+
+// MemoryVault is a TokenVault that keeps tokens in memory, they are lost when the process exits.
+type MemoryVault struct {
+	mu sync.RWMutex
+	// tokens maps values to their token
+	tokens map[string]string
+	// values maps tokens to their value
+	values map[string]string
+}
+
+// NewMemoryVault creates an empty MemoryVault.
+func NewMemoryVault() *MemoryVault {
+	return &MemoryVault{
+		tokens: map[string]string{},
+		values: map[string]string{},
+	}
+}
+
+func (v *MemoryVault) Tokenize(value string) (string, error) {
+	return v.tokenize(value, nil)
+}
+
+func (v *MemoryVault) Detokenize(token string) (string, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	value, ok := v.values[token]
+	if !ok {
+		return "", ErrTokenNotFound
+	}
+	return value, nil
+}
+
+// tokenize returns the token of a value, generating one if there isn't one yet.
+// New tokens are passed to persist (if set) before they are stored.
+func (v *MemoryVault) tokenize(value string, persist func(token, value string) error) (string, error) {
+	v.mu.RLock()
+	token, ok := v.tokens[value]
+	v.mu.RUnlock()
+	if ok {
+		return token, nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if token, ok := v.tokens[value]; ok {
+		return token, nil
+	}
+	for {
+		var err error
+		if token, err = newToken(); err != nil {
+			return "", err
+		}
+		if _, taken := v.values[token]; !taken {
+			break
+		}
+	}
+	if persist != nil {
+		if err := persist(token, value); err != nil {
+			return "", err
+		}
+	}
+	v.tokens[value] = token
+	v.values[token] = value
+	return token, nil
+}
+
+// FileVault is a TokenVault that appends every token it issues to a file, so tokens survive restarts.
+// The file holds the original values and is created readable only by its owner.
+type FileVault struct {
+	memory *MemoryVault
+	file   *os.File
+}
+
+// fileVaultEntry is a line of the file of a FileVault, values are stored as bytes so invalid UTF-8 survives the round trip.
+type fileVaultEntry struct {
+	Token string `json:"token"`
+	Value []byte `json:"value"`
+}
+
+// OpenFileVault opens (or creates) the file of a FileVault and loads the tokens already in it.
+func OpenFileVault(path string) (*FileVault, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening token vault")
+	}
+	var v = &FileVault{memory: NewMemoryVault(), file: file}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		var entry fileVaultEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			_ = file.Close()
+			return nil, errors.Wrap(err, "reading token vault")
+		}
+		v.memory.tokens[string(entry.Value)] = entry.Token
+		v.memory.values[entry.Token] = string(entry.Value)
+	}
+	if err := scanner.Err(); err != nil {
+		_ = file.Close()
+		return nil, errors.Wrap(err, "reading token vault")
+	}
+	return v, nil
+}
+
+func (v *FileVault) Tokenize(value string) (string, error) {
+	return v.memory.tokenize(value, v.persist)
+}
+
+func (v *FileVault) Detokenize(token string) (string, error) {
+	return v.memory.Detokenize(token)
+}
+
+// persist appends a token to the file, it is synced so a token is never handed out before it is stored.
+func (v *FileVault) persist(token, value string) error {
+	line, err := json.Marshal(fileVaultEntry{Token: token, Value: []byte(value)})
+	if err != nil {
+		return errors.Wrap(err, "writing token vault")
+	}
+	if _, err = v.file.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "writing token vault")
+	}
+	return errors.Wrap(v.file.Sync(), "writing token vault")
+}
+
+// Close closes the file of the vault, tokens can not be issued afterwards.
+func (v *FileVault) Close() error {
+	return v.file.Close()
+}
@@ -0,0 +1,91 @@
+package redaction
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type tokenizedRecord struct {
+	Email string `redact:"~support=tokenize"`
+}
+
+func TestTokenize(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		r := New(WithTokenVault(NewMemoryVault(), "Support"))
+		require.NoError(t, r.Implies("lead", "support"))
+		clean, err := RedactWith(r, tokenizedRecord{Email: "jane@example.com"}, "analyst")
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(clean.Email, TokenPrefix))
+		again, err := RedactWith(r, tokenizedRecord{Email: "jane@example.com"})
+		require.NoError(t, err)
+		require.Equal(t, clean, again)
+		other, err := RedactWith(r, tokenizedRecord{Email: "john@example.com"})
+		require.NoError(t, err)
+		require.NotEqual(t, clean.Email, other.Email)
+
+		value, err := r.Detokenize(clean.Email, "support")
+		require.NoError(t, err)
+		require.Equal(t, "jane@example.com", value)
+		value, err = r.DetokenizeCtx(WithGroups(context.Background(), "lead"), clean.Email)
+		require.NoError(t, err)
+		require.Equal(t, "jane@example.com", value)
+		_, err = r.Detokenize(clean.Email, "analyst")
+		require.True(t, errors.Is(err, ErrUnauthorized))
+		_, err = r.Detokenize(clean.Email)
+		require.True(t, errors.Is(err, ErrUnauthorized))
+		_, err = r.Detokenize("tok_unknown", "support")
+		require.True(t, errors.Is(err, ErrTokenNotFound))
+	})
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tokens")
+		vault, err := OpenFileVault(path)
+		require.NoError(t, err)
+		var values = []string{"jane@example.com", "invalid \xff utf-8", ""}
+		var tokens []string
+		for _, value := range values {
+			token, err := vault.Tokenize(value)
+			require.NoError(t, err)
+			tokens = append(tokens, token)
+		}
+		require.NoError(t, vault.Close())
+
+		vault, err = OpenFileVault(path)
+		require.NoError(t, err)
+		defer vault.Close()
+		for i, value := range values {
+			token, err := vault.Tokenize(value)
+			require.NoError(t, err)
+			require.Equal(t, tokens[i], token)
+			detokenized, err := vault.Detokenize(token)
+			require.NoError(t, err)
+			require.Equal(t, value, detokenized)
+		}
+	})
+	t.Run("concurrent", func(t *testing.T) {
+		vault := NewMemoryVault()
+		var wg sync.WaitGroup
+		var tokens = make([]string, 32)
+		for i := range tokens {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				tokens[i], _ = vault.Tokenize("value")
+			}(i)
+		}
+		wg.Wait()
+		for _, token := range tokens {
+			require.Equal(t, tokens[0], token)
+		}
+	})
+	t.Run("vault required", func(t *testing.T) {
+		_, err := RedactRecord(tokenizedRecord{Email: "jane@example.com"})
+		require.True(t, errors.Is(err, ErrTokenVaultRequired))
+		_, err = New().Detokenize("tok_unknown", "support")
+		require.True(t, errors.Is(err, ErrTokenVaultRequired))
+	})
+}